@@ -0,0 +1,86 @@
+// Copyright (c) 2018-2024 KIDTSUNAMI
+// Author: alex@kidtsunami.com
+
+package config
+
+import (
+	"encoding/json"
+	"math/big"
+	"strings"
+)
+
+func GetBigInt(path string) *big.Int {
+	return config.GetBigInt(path)
+}
+
+func GetBigFloat(path string) *big.Float {
+	return config.GetBigFloat(path)
+}
+
+// GetBigInt returns an arbitrary-precision integer. Strings may use
+// 0x, 0o and 0b base prefixes, leading zeros without prefix are invalid.
+// Missing or invalid values return zero.
+func (c *Config) GetBigInt(path string) *big.Int {
+	val := c.getValue(path)
+	if val == nil {
+		return new(big.Int)
+	}
+	switch v := val.(type) {
+	case *big.Int:
+		return new(big.Int).Set(v)
+	case big.Int:
+		return new(big.Int).Set(&v)
+	case float64:
+		i, _ := big.NewFloat(v).Int(nil)
+		return i
+	case json.Number:
+		if i, ok := new(big.Int).SetString(v.String(), 10); ok {
+			return i
+		}
+		if f, ok := parseBigFloat(v.String()); ok {
+			i, _ := f.Int(nil)
+			return i
+		}
+	default:
+		s := strings.TrimSpace(toString(v))
+		if leadingZero(s) {
+			break
+		}
+		if i, ok := new(big.Int).SetString(s, 0); ok {
+			return i
+		}
+	}
+	return new(big.Int)
+}
+
+// GetBigFloat returns an arbitrary-precision float. The precision is
+// derived from the number of digits in the configured value. Missing or
+// invalid values return zero.
+func (c *Config) GetBigFloat(path string) *big.Float {
+	val := c.getValue(path)
+	if val == nil {
+		return new(big.Float)
+	}
+	switch v := val.(type) {
+	case *big.Float:
+		return new(big.Float).Copy(v)
+	case *big.Int:
+		return new(big.Float).SetInt(v)
+	case float64:
+		return big.NewFloat(v)
+	default:
+		if f, ok := parseBigFloat(strings.TrimSpace(toString(v))); ok {
+			return f
+		}
+	}
+	return new(big.Float)
+}
+
+func parseBigFloat(s string) (*big.Float, bool) {
+	prec := uint(len(s)) * 4 // > log2(10) bits per decimal digit
+	if prec < 64 {
+		prec = 64
+	}
+	f, _, err := big.ParseFloat(s, 0, prec, big.ToNearestEven)
+	return f, err == nil
+}
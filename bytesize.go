@@ -0,0 +1,157 @@
+// Copyright (c) 2018-2024 KIDTSUNAMI
+// Author: alex@kidtsunami.com
+
+package config
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// ByteSize is a size in bytes that can be written in human-readable form
+// using SI (kB, MB, GB, ...) or IEC (KiB, MiB, GiB, ...) units. Single
+// letter units (k, m, g, ...) are interpreted as IEC units.
+type ByteSize uint64
+
+const (
+	Byte ByteSize = 1
+
+	KB ByteSize = 1000
+	MB          = KB * 1000
+	GB          = MB * 1000
+	TB          = GB * 1000
+	PB          = TB * 1000
+	EB          = PB * 1000
+
+	KiB ByteSize = 1 << 10
+	MiB          = KiB << 10
+	GiB          = MiB << 10
+	TiB          = GiB << 10
+	PiB          = TiB << 10
+	EiB          = PiB << 10
+)
+
+var byteSizeUnits = map[string]ByteSize{
+	"":    Byte,
+	"b":   Byte,
+	"k":   KiB,
+	"m":   MiB,
+	"g":   GiB,
+	"t":   TiB,
+	"p":   PiB,
+	"e":   EiB,
+	"kb":  KB,
+	"mb":  MB,
+	"gb":  GB,
+	"tb":  TB,
+	"pb":  PB,
+	"eb":  EB,
+	"kib": KiB,
+	"mib": MiB,
+	"gib": GiB,
+	"tib": TiB,
+	"pib": PiB,
+	"eib": EiB,
+}
+
+var (
+	iecUnits = []string{"EiB", "PiB", "TiB", "GiB", "MiB", "KiB"}
+	siUnits  = []string{"EB", "PB", "TB", "GB", "MB", "kB"}
+)
+
+func (s ByteSize) Bytes() uint64 {
+	return uint64(s)
+}
+
+// String formats the size using the largest IEC or SI unit that divides
+// it without remainder so that the result parses back to the same value.
+func (s ByteSize) String() string {
+	if s == 0 {
+		return "0B"
+	}
+	for _, units := range [][]string{iecUnits, siUnits} {
+		for _, u := range units {
+			m := byteSizeUnits[strings.ToLower(u)]
+			if s >= m && s%m == 0 {
+				return strconv.FormatUint(uint64(s/m), 10) + u
+			}
+		}
+	}
+	return strconv.FormatUint(uint64(s), 10) + "B"
+}
+
+func (s ByteSize) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+func (s *ByteSize) UnmarshalText(buf []byte) error {
+	v, err := ParseByteSize(string(buf))
+	if err != nil {
+		return err
+	}
+	*s = v
+	return nil
+}
+
+func (s ByteSize) MarshalJSON() ([]byte, error) {
+	return []byte(strconv.Quote(s.String())), nil
+}
+
+// UnmarshalJSON accepts quoted size strings and plain JSON numbers.
+func (s *ByteSize) UnmarshalJSON(buf []byte) error {
+	if str, err := strconv.Unquote(string(buf)); err == nil {
+		buf = []byte(str)
+	}
+	return s.UnmarshalText(buf)
+}
+
+func ParseByteSize(s string) (ByteSize, error) {
+	str := strings.TrimSpace(s)
+	i := strings.IndexFunc(str, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.' && r != '_'
+	})
+	if i < 0 {
+		i = len(str)
+	}
+	num, unit := str[:i], strings.ToLower(strings.TrimSpace(str[i:]))
+	mul, ok := byteSizeUnits[unit]
+	if !ok || num == "" {
+		return 0, fmt.Errorf("bytesize: parsing %q: invalid syntax", s)
+	}
+	num = strings.Replace(num, "_", "", -1)
+	if u, err := strconv.ParseUint(num, 10, 64); err == nil {
+		if u > math.MaxUint64/uint64(mul) {
+			return 0, fmt.Errorf("bytesize: parsing %q: value out of range", s)
+		}
+		return ByteSize(u) * mul, nil
+	}
+	f, err := strconv.ParseFloat(num, 64)
+	if err != nil {
+		return 0, fmt.Errorf("bytesize: parsing %q: invalid syntax", s)
+	}
+	f *= float64(mul)
+	if f >= math.MaxUint64 {
+		return 0, fmt.Errorf("bytesize: parsing %q: value out of range", s)
+	}
+	return ByteSize(math.Round(f)), nil
+}
+
+// intByteSize converts i to a size. Negative values are 0.
+func intByteSize(i int64) ByteSize {
+	if i < 0 {
+		return 0
+	}
+	return ByteSize(i)
+}
+
+// floatByteSize rounds f to whole bytes like ParseByteSize. Negative and
+// out of range values are 0.
+func floatByteSize(f float64) ByteSize {
+	f = math.Round(f)
+	if !(f >= 0 && f < math.MaxUint64) {
+		return 0
+	}
+	return ByteSize(f)
+}
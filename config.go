@@ -5,13 +5,18 @@
 package config
 
 import (
+	"bytes"
+	"crypto/cipher"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
-	"regexp"
+	"reflect"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -81,6 +86,10 @@ func GetTime(path string) time.Time {
 	return config.GetTime(path)
 }
 
+func GetByteSize(path string) ByteSize {
+	return config.GetByteSize(path)
+}
+
 func GetBool(path string) bool {
 	return config.GetBool(path)
 }
@@ -137,6 +146,10 @@ func ForEach(path string, fn func(c *Config) error) error {
 	return config.ForEach(path, fn)
 }
 
+func ForEachKey(path string, fn func(name string, c *Config) error) error {
+	return config.ForEachKey(path, fn)
+}
+
 func Branch(path string) (*Config, error) {
 	return config.Branch(path)
 }
@@ -149,21 +162,48 @@ func Expand(s string) string {
 	return config.Expand(s)
 }
 
+func ExpandE(s string) (string, error) {
+	return config.ExpandE(s)
+}
+
 type Config struct {
-	confName   string
-	envPrefix  string
-	branchName string
-	noEnv      bool
-	data       map[string]any // read from config file or set
-	merged     map[string]any // merged env, data, defaults
-	defaults   map[string]any // flat 1-level key/value pairs
+	confName       string
+	envPrefix      string
+	keyPrefix      string // key path of branches and slice elements, used for env names
+	envMapper      EnvKeyMapper
+	keyNorm        KeyNormalizer
+	branchName     string
+	parent         *Config // parent of a view created by Sub
+	subPath        string  // path of a view in its parent
+	noEnv          bool
+	strict         bool
+	format         string // config file format, detected from name when empty
+	backup         bool   // keep .bak copy when writing config files
+	interpolate    bool
+	resolveSecrets bool
+	resolvers      map[string]SecretResolver
+	secrets        map[string]string // resolved secret cache
+	secretMu       sync.Mutex        // guards secrets and aead
+	aead           cipher.AEAD       // decrypts enc:v1: values
+	secretKeys     map[string]bool
+	secretPatterns []string       // nil uses DefaultSecretPatterns
+	data           map[string]any // read from config file or set
+	merged         map[string]any // merged env, data, defaults
+	defaults       map[string]any // flat 1-level key/value pairs
+	enums          map[string][]string
+	specs          map[string]*Spec
+	schema         *jsonSchema
+	sources        map[string]keyPos // where keys in data were read from
 }
 
 func NewConfig() *Config {
 	return &Config{
-		data:     make(map[string]any),
-		defaults: make(map[string]any),
-		merged:   nil,
+		data:      make(map[string]any),
+		defaults:  make(map[string]any),
+		enums:     make(map[string][]string),
+		specs:     make(map[string]*Spec),
+		resolvers: defaultResolvers(),
+		merged:    nil,
 	}
 }
 
@@ -173,9 +213,12 @@ func canAccess(name string) bool {
 }
 
 func (c *Config) ConfigName() string {
+	if c.parent != nil {
+		return c.parent.ConfigName()
+	}
 	name := c.confName
 	if name == "" || !canAccess(name) {
-		name = os.Getenv(c.expandEnvKey("CONFIG_FILE"))
+		name = os.Getenv(c.controlEnv("CONFIG_FILE"))
 	}
 	if name == "" || !canAccess(name) {
 		name = "config.json"
@@ -184,21 +227,36 @@ func (c *Config) ConfigName() string {
 }
 
 func (c *Config) SetConfigName(name string) *Config {
+	if c.parent != nil {
+		c.parent.SetConfigName(name)
+		return c
+	}
 	c.confName = name
 	return c
 }
 
 func (c *Config) SetEnvPrefix(p string) *Config {
+	if c.parent != nil {
+		c.parent.SetEnvPrefix(p)
+		return c
+	}
 	c.envPrefix = strings.ToUpper(strings.Replace(p, " ", "_", -1))
 	c.merged = nil
 	return c
 }
 
 func (c *Config) EnvPrefix() string {
+	if c.parent != nil {
+		return c.parent.EnvPrefix()
+	}
 	return c.envPrefix
 }
 
 func (c *Config) UseEnv(enabled bool) *Config {
+	if c.parent != nil {
+		c.parent.UseEnv(enabled)
+		return c
+	}
 	c.noEnv = !enabled
 	return c
 }
@@ -228,43 +286,132 @@ func (c *Config) ReadConfigFile(failNonExist ...bool) error {
 	if err != nil {
 		return fmt.Errorf("reading config file: %v", err)
 	}
-	return c.ReadConfig(buf)
+	return c.readConfig(buf, c.configFormat(name), name)
 }
 
+// ReadConfig parses buf as JSON or in the format set by SetConfigFormat.
 func (c *Config) ReadConfig(buf []byte) error {
-	// unpack config from JSON into Go map
-	if err := json.Unmarshal(buf, &c.data); err != nil {
+	return c.readConfig(buf, c.configFormat(""), "")
+}
+
+func (c *Config) readConfig(buf []byte, format, name string) error {
+	if c.parent != nil {
+		return fmt.Errorf("reading config: views cannot replace config data, use Set")
+	}
+	// unpack config into Go map, keep numbers at full precision
+	data, offsets, err := decodeConfig(buf, format)
+	if err != nil {
+		if pe, ok := err.(*ParseError); ok {
+			pe.File = name
+			return pe
+		}
 		return fmt.Errorf("parsing config file: %v", err)
 	}
+	if data == nil {
+		data = make(map[string]any)
+	}
+	sources := c.newSources(buf, name, data, offsets)
+	// keep existing top-level keys that are not present in buf
+	for k, v := range c.data {
+		if _, ok := data[k]; !ok {
+			data[k] = v
+		}
+	}
+	// validate before the new data replaces the current config
+	if err := c.checkData(data, sources); err != nil {
+		return err
+	}
+	c.data = data
+	c.sources = sources
 	c.merged = nil
+	c.ResetSecrets()
 	// parse env for any defined value
 	_ = c.All()
 	return nil
 }
 
+// checkData checks data for unknown keys in strict mode and validates it
+// against the schema. Schema errors are located using sources.
+func (c *Config) checkData(data map[string]any, sources map[string]keyPos) error {
+	if c.strict {
+		if err := c.checkUnknown(data); err != nil {
+			return err
+		}
+	}
+	if c.schema != nil {
+		if err := c.schema.validate(c.merge(data)); err != nil {
+			return annotateSchema(err, func(path string) (keyPos, bool) {
+				pos, ok := sources[normPath(c.key(path))]
+				return pos, ok
+			})
+		}
+	}
+	return nil
+}
+
+// controlEnv returns the env variable for control setting name, like
+// CONFIG_FILE. Unlike config keys, control names are not normalized.
+func (c *Config) controlEnv(name string) string {
+	name = c.envKeyMapper().ToEnv(name)
+	if c.envPrefix != "" {
+		return c.envPrefix + "_" + name
+	}
+	return name
+}
+
+// isControlEnv reports whether env variable name configures the config
+// file or the encryption key. Such variables are never config data.
+func (c *Config) isControlEnv(name string) bool {
+	switch name {
+	case c.controlEnv("CONFIG_FILE"), c.controlEnv("CONFIG_KEY"), c.controlEnv("CONFIG_KEY_FILE"):
+		return true
+	}
+	return false
+}
+
 func (c *Config) expandEnvKey(key string) string {
-	key = strings.ToUpper(key)
-	key = strings.Replace(key, ".", "_", -1)
+	key = c.envKeyMapper().ToEnv(strings.Join(splitPath(c.key(joinPath(c.keyPrefix, key))), "."))
 	if c.envPrefix != "" {
 		return c.envPrefix + "_" + key
 	}
 	return key
 }
 
+// Set stores val at key in config data. Numeric key segments address
+// slice elements and an index equal to the slice length appends. Keys
+// that do not fit the existing tree, like out of range indexes, are
+// ignored.
 func (c *Config) Set(key string, val any) *Config {
-	setTree(c.data, key, val)
+	if c.parent != nil {
+		c.parent.Set(c.fullPath(key), val)
+		return c
+	}
+	_ = c.setIn(c.data, key, val, true)
+	c.forgetSource(key)
 	c.merged = nil
 	return c
 }
 
 func (c *Config) Use(val map[string]any) *Config {
+	if c.parent != nil {
+		if c.subPath == "" {
+			c.parent.Use(val)
+		} else {
+			c.parent.Set(c.subPath, val)
+		}
+		return c
+	}
 	c.data = val
 	c.merged = nil
 	return c
 }
 
 func (c *Config) SetDefault(key string, val any) *Config {
-	c.defaults[key] = val // flat
+	if c.parent != nil {
+		c.parent.SetDefault(c.fullPath(key), val)
+		return c
+	}
+	c.defaults[normPath(c.key(key))] = val // flat
 	c.merged = nil
 	return c
 }
@@ -273,35 +420,119 @@ func (c *Config) getEnv(path string) (string, bool) {
 	if c.noEnv {
 		return "", false
 	}
-	path = strings.ToUpper(path)
-	path = strings.Replace(path, ".", "_", -1)
 	return os.LookupEnv(c.expandEnvKey(path))
 }
 
+// parseEnvValue decodes env values holding JSON arrays or objects, like
+// APP_SERVERS='[{"host":"a"}]', for keys registered as slice or map by
+// their default or spec. Other values are returned as string.
+func (c *Config) parseEnvValue(key, s string) any {
+	if !c.isContainer(key) {
+		return s
+	}
+	t := strings.TrimSpace(s)
+	if len(t) < 2 || !(t[0] == '[' && t[len(t)-1] == ']' || t[0] == '{' && t[len(t)-1] == '}') {
+		return s
+	}
+	var val any
+	if err := decodeJSON([]byte(t), &val); err != nil {
+		return s
+	}
+	return val
+}
+
+// isContainer reports whether key is registered as slice or map.
+func (c *Config) isContainer(key string) bool {
+	key = normPath(c.key(key))
+	if spec, ok := c.specs[key]; ok && (spec.Type == TypeSlice || spec.Type == TypeMap) {
+		return true
+	}
+	if val, ok := c.defaults[key]; ok && val != nil {
+		switch reflect.TypeOf(val).Kind() {
+		case reflect.Slice, reflect.Array, reflect.Map:
+			return true
+		}
+	}
+	return false
+}
+
 func (c *Config) getValue(path string) any {
+	val, _ := c.getValueE(path)
+	return val
+}
+
+// getValueE returns the value at path with resolved secret references
+// and, when enabled, interpolated references.
+func (c *Config) getValueE(path string) (any, error) {
+	return c.resolveValue(path, nil)
+}
+
+// resolveValue returns the value at path resolved below the references
+// in stack.
+func (c *Config) resolveValue(path string, stack []string) (any, error) {
+	if c.parent != nil {
+		return c.parent.resolveValue(c.fullPath(path), stack)
+	}
+	val := c.getRawValue(path)
+	if val == nil {
+		return val, nil
+	}
+	return c.interpolateValue(path, val, stack)
+}
+
+func (c *Config) getRawValue(path string) any {
+	val, _ := c.lookupRaw(path)
+	return val
+}
+
+// lookupRaw returns the unresolved value at path and its state. Explicit
+// null values in env, merged tree or data hide registered defaults.
+func (c *Config) lookupRaw(path string) (any, State) {
+	if c.parent != nil {
+		return c.parent.lookupRaw(c.fullPath(path))
+	}
+	path = c.key(path)
 	// get env key when present (allows to overwrite with empty value)
 	if val, ok := c.getEnv(path); ok {
-		return val
+		if val == EnvUnset {
+			return nil, Null
+		}
+		return c.parseEnvValue(path, val), Present
+	}
+	// get from JSON values of parent env keys
+	segs := splitPath(path)
+	for i := len(segs) - 1; i > 0; i-- {
+		if val, ok := c.getEnv(strings.Join(segs[:i], ".")); ok {
+			if val == EnvUnset {
+				return nil, Null
+			}
+			if val, ok := c.lookupIn(c.parseEnvValue(strings.Join(segs[:i], "."), val), segs[i:]); ok {
+				return val, stateOf(val)
+			}
+			break
+		}
 	}
 	// try get merged tree (env + config + defaults)
 	if c.merged != nil {
-		if val := getTree(c.merged, path); val != nil {
-			return val
+		if val := c.getIn(c.merged, path); val != nil {
+			return val, Present
 		}
 	}
 	// get config file data if set
 	if c.data != nil {
-		if val := getTree(c.data, path); val != nil {
-			return val
+		if val, ok := c.lookupIn(c.data, segs); ok {
+			return val, stateOf(val)
 		}
 	}
 	// get default if registered
-	if val, ok := c.defaults[path]; ok {
-		return val
+	if val, ok := c.defaults[normPath(path)]; ok && val != nil {
+		return val, Present
 	}
-	return nil
+	return nil, Missing
 }
 
+// Has reports whether path has a value. Use Lookup to tell missing keys
+// from explicit null values.
 func (c *Config) Has(path string) bool {
 	val := c.getValue(path)
 	return val != nil
@@ -320,6 +551,10 @@ func (c *Config) GetStringSlice(path string) []string {
 	if val == nil {
 		return []string{}
 	}
+	return toStringSlice(val)
+}
+
+func toStringSlice(val any) []string {
 	switch s := val.(type) {
 	case []string:
 		return s
@@ -334,7 +569,13 @@ func (c *Config) GetStringSlice(path string) []string {
 	}
 }
 
+// GetStringMap returns the map at path with string values. Values may
+// also be lists of key=value pairs. Keys are normalized by the key
+// normalizer or lowercased when none is set.
 func (c *Config) GetStringMap(path string) map[string]string {
+	if c.parent != nil {
+		return c.parent.GetStringMap(c.fullPath(path))
+	}
 	val := c.getValue(path)
 	smap := make(map[string]string)
 	if val == nil {
@@ -345,7 +586,7 @@ func (c *Config) GetStringMap(path string) map[string]string {
 		smap = m
 	case map[string]any:
 		for k, v := range m {
-			k = strings.ToLower(k)
+			k = c.mapKey(k)
 			if s := toString(v); s != "" {
 				smap[k] = s
 			}
@@ -353,7 +594,7 @@ func (c *Config) GetStringMap(path string) map[string]string {
 	case []string:
 		for _, v := range m {
 			k, v, ok := strings.Cut(v, "=")
-			k = strings.ToLower(k)
+			k = c.mapKey(k)
 			if ok {
 				smap[k] = v
 			} else {
@@ -363,7 +604,7 @@ func (c *Config) GetStringMap(path string) map[string]string {
 	case string:
 		for _, v := range strings.Split(m, ",") {
 			k, v, ok := strings.Cut(v, "=")
-			k = strings.ToLower(k)
+			k = c.mapKey(k)
 			if ok {
 				smap[k] = v
 			} else {
@@ -384,8 +625,8 @@ func (c *Config) GetStringMap(path string) map[string]string {
 			continue
 		}
 		k, v, _ := strings.Cut(v, "=")
-		k = strings.TrimPrefix(k, pfx+"_")
-		k = strings.ToLower(k)
+		// map keys are no paths, so '_' is kept
+		k = c.mapKey(strings.TrimLeft(strings.TrimPrefix(k, pfx), "_"))
 		smap[k] = v
 	}
 	return smap
@@ -415,6 +656,13 @@ func (c *Config) GetDuration(path string) time.Duration {
 		return time.Duration(v)
 	case float64:
 		return time.Duration(int64(v))
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return time.Duration(n)
+		}
+		if f, err := v.Float64(); err == nil {
+			return time.Duration(int64(f))
+		}
 	case string:
 		if dur, err := ParseDuration(v); err == nil {
 			return dur.Duration()
@@ -447,6 +695,13 @@ func (c *Config) GetTime(path string) time.Time {
 		return time.Unix(int64(v), 0)
 	case float64:
 		return time.Unix(int64(v), 0)
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return time.Unix(n, 0)
+		}
+		if f, err := v.Float64(); err == nil {
+			return time.Unix(int64(f), 0)
+		}
 	case string:
 		if tm, err := ParseTime(v); err == nil {
 			return tm
@@ -459,74 +714,82 @@ func (c *Config) GetTime(path string) time.Time {
 	return time.Time{}
 }
 
-func (c *Config) GetBool(path string) bool {
-	val := c.getValue(path)
-	if val == nil {
-		return false
-	}
-	switch v := val.(type) {
-	case bool:
-		return v
-	default:
-		s := toString(v)
-		if b, err := strconv.ParseBool(s); err == nil {
-			return b
-		}
-	}
-	return false
-}
-
-func (c *Config) GetInt(path string) int {
+func (c *Config) GetByteSize(path string) ByteSize {
 	val := c.getValue(path)
 	if val == nil {
 		return 0
 	}
 	switch v := val.(type) {
+	case ByteSize:
+		return v
+	case int:
+		return intByteSize(int64(v))
+	case int32:
+		return intByteSize(int64(v))
+	case uint32:
+		return ByteSize(v)
 	case int64:
-		return int(v)
+		return intByteSize(v)
+	case uint64:
+		return ByteSize(v)
 	case float64:
-		return int(v)
+		return floatByteSize(v)
 	case json.Number:
-		if n, err := v.Int64(); err == nil {
-			return int(n)
+		if n, err := strconv.ParseUint(v.String(), 10, 64); err == nil {
+			return ByteSize(n)
+		}
+		if f, err := v.Float64(); err == nil {
+			return floatByteSize(f)
+		}
+	case string:
+		if s, err := ParseByteSize(v); err == nil {
+			return s
 		}
 	default:
-		s := toString(v)
-		if i, err := strconv.ParseInt(s, 10, 64); err == nil {
-			return int(i)
+		if s, err := ParseByteSize(toString(v)); err == nil {
+			return s
 		}
 	}
 	return 0
 }
 
-func (c *Config) GetUint(path string) uint {
+func (c *Config) GetBool(path string) bool {
 	val := c.getValue(path)
 	if val == nil {
-		return 0
+		return false
 	}
 	switch v := val.(type) {
-	case uint64:
-		return uint(v)
-	case float64:
-		return uint(v)
-	case json.Number:
-		if n, err := v.Int64(); err == nil {
-			return uint(n)
-		}
+	case bool:
+		return v
 	default:
 		s := toString(v)
-		if i, err := strconv.ParseUint(s, 10, 64); err == nil {
-			return uint(i)
+		if b, err := strconv.ParseBool(s); err == nil {
+			return b
 		}
 	}
-	return 0
+	return false
+}
+
+// GetInt returns the integer at path. Values that are no integers or do
+// not fit into int return 0, use GetIntE to see the error.
+func (c *Config) GetInt(path string) int {
+	i, _ := c.getInt(path, strconv.IntSize)
+	return int(i)
+}
+
+// GetUint returns the unsigned integer at path. Values that are no
+// unsigned integers or do not fit into uint return 0, use GetUintE to see
+// the error.
+func (c *Config) GetUint(path string) uint {
+	i, _ := c.getUint(path, strconv.IntSize)
+	return uint(i)
 }
 
 func (c *Config) GetIntSlice(path string) []int {
 	is := make([]int, 0)
 	for _, v := range c.GetStringSlice(path) {
-		val, _ := strconv.Atoi(v)
-		is = append(is, val)
+		val, _ := parseInt(v, strconv.IntSize)
+		is = append(is, int(val))
 	}
 	return is
 }
@@ -534,62 +797,28 @@ func (c *Config) GetIntSlice(path string) []int {
 func (c *Config) GetUintSlice(path string) []uint {
 	is := make([]uint, 0)
 	for _, v := range c.GetStringSlice(path) {
-		val, _ := strconv.Atoi(v)
+		val, _ := parseUint(v, strconv.IntSize)
 		is = append(is, uint(val))
 	}
 	return is
 }
 
+// GetInt64 works like GetInt for int64, see GetInt64E.
 func (c *Config) GetInt64(path string) int64 {
-	val := c.getValue(path)
-	if val == nil {
-		return 0
-	}
-	switch v := val.(type) {
-	case int64:
-		return v
-	case float64:
-		return int64(v)
-	case json.Number:
-		if n, err := v.Int64(); err == nil {
-			return n
-		}
-	default:
-		s := toString(v)
-		if i, err := strconv.ParseInt(s, 10, 64); err == nil {
-			return i
-		}
-	}
-	return 0
+	i, _ := c.getInt(path, 64)
+	return i
 }
 
+// GetUint64 works like GetUint for uint64, see GetUint64E.
 func (c *Config) GetUint64(path string) uint64 {
-	val := c.getValue(path)
-	if val == nil {
-		return 0
-	}
-	switch v := val.(type) {
-	case uint64:
-		return v
-	case float64:
-		return uint64(v)
-	case json.Number:
-		if n, err := v.Int64(); err == nil {
-			return uint64(n)
-		}
-	default:
-		s := toString(v)
-		if i, err := strconv.ParseUint(s, 10, 64); err == nil {
-			return i
-		}
-	}
-	return 0
+	i, _ := c.getUint(path, 64)
+	return i
 }
 
 func (c *Config) GetInt64Slice(path string) []int64 {
 	is := make([]int64, 0)
 	for _, v := range c.GetStringSlice(path) {
-		val, _ := strconv.ParseInt(v, 10, 64)
+		val, _ := parseInt(v, 64)
 		is = append(is, val)
 	}
 	return is
@@ -598,7 +827,7 @@ func (c *Config) GetInt64Slice(path string) []int64 {
 func (c *Config) GetUint64Slice(path string) []uint64 {
 	is := make([]uint64, 0)
 	for _, v := range c.GetStringSlice(path) {
-		val, _ := strconv.ParseUint(v, 10, 64)
+		val, _ := parseUint(v, 64)
 		is = append(is, val)
 	}
 	return is
@@ -637,67 +866,128 @@ func (c *Config) GetFloat64Slice(path string) []float64 {
 }
 
 func (c *Config) All() map[string]any {
-	if c.merged != nil {
-		return c.merged
+	all, _ := c.allE()
+	return all
+}
+
+// allE returns the merged tree with, when enabled, resolved secret and
+// interpolated references. Only branches containing resolved values are
+// copied. Resolution errors are reported.
+func (c *Config) allE() (map[string]any, error) {
+	if c.parent != nil {
+		node, err := c.parent.resolveNode(c.subPath)
+		sub, _ := node.(map[string]any)
+		if sub == nil {
+			sub = make(map[string]any)
+		}
+		return sub, err
 	}
-	c.merged = make(map[string]any)
+	all, err := c.resolveNode("")
+	return all.(map[string]any), err
+}
+
+// resolveNode returns the merged node at path, or nil when it is missing.
+// Only references below path are resolved, so errors elsewhere in the
+// tree do not fail the lookup.
+func (c *Config) resolveNode(path string) (any, error) {
+	if c.parent != nil {
+		return c.parent.resolveNode(c.fullPath(path))
+	}
+	if c.merged == nil {
+		c.merged = c.merge(c.data)
+	}
+	if path == "" {
+		return c.interpolateValue("", c.merged, nil)
+	}
+	node := c.getIn(c.merged, path)
+	if node == nil {
+		return nil, nil
+	}
+	return c.interpolateValue(path, node, nil)
+}
+
+// merge builds a merged tree from data, registered defaults and env.
+func (c *Config) merge(data map[string]any) map[string]any {
+	merged := make(map[string]any)
+	defer func() { pruneNull(merged) }()
 
 	// load data map into merged
-	buf, _ := json.Marshal(&c.data)
-	json.Unmarshal(buf, &c.merged)
+	buf, _ := json.Marshal(&data)
+	decodeJSON(buf, &merged)
+	if merged == nil {
+		merged = make(map[string]any)
+	}
 
 	// add defaults for missing (nested) keys
 	for key, val := range c.defaults {
-		setTreeIfEmpty(c.merged, key, val)
+		_ = c.setIn(merged, key, val, false)
 	}
 
 	// extend keys with matching env variables, only if env prefix is set
 	if c.noEnv || c.envPrefix == "" {
-		return c.merged
+		return merged
 	}
 
-	for _, v := range os.Environ() {
-		if !strings.HasPrefix(v, c.envPrefix) {
+	// apply parents before children and slice elements in index order
+	env := os.Environ()
+	sort.Slice(env, func(i, j int) bool {
+		ki, _, _ := strings.Cut(env[i], "=")
+		kj, _, _ := strings.Cut(env[j], "=")
+		if len(ki) != len(kj) {
+			return len(ki) < len(kj)
+		}
+		return ki < kj
+	})
+	mapper, known := c.envKeyMapper(), c.registeredKeys()
+	for _, v := range env {
+		if !strings.HasPrefix(v, c.envPrefix+"_") {
 			continue
 		}
 		key, val, _ := strings.Cut(v, "=")
-		key = strings.Join(strings.Split(strings.ToLower(key), "_")[1:], ".")
-		setTree(c.merged, key, val)
+		if c.isControlEnv(key) {
+			continue
+		}
+		key = c.key(mapper.FromEnv(strings.TrimPrefix(key, c.envPrefix+"_"), known))
+		if val == EnvUnset {
+			c.deleteIn(merged, key)
+			continue
+		}
+		// ignore env keys that do not fit the tree, e.g. out of range indexes
+		_ = c.setIn(merged, key, c.parseEnvValue(key, val), true)
 	}
-	return c.merged
+	return merged
 }
 
+// ForEach calls fn with a view of every element of the slice at path,
+// including elements that exist only in env. Elements must be maps.
+// See Sub for views and ForEachInto for other element types.
 func (c *Config) ForEach(path string, fn func(c *Config) error) error {
-	// requires merged tree
-	s := c.All()
-	segs := strings.Split(path, ".")
-	var slice []any
-	for i, v := range segs[:len(segs)-1] {
-		if s == nil {
-			break
-		}
-		sub, ok := s[v]
-		if !ok {
-			return fmt.Errorf("missing config path %q", path)
-		}
-		s, ok = sub.(map[string]any)
-		if !ok && i < len(segs)-1 {
-			return fmt.Errorf("invalid type %T at config path %q", sub, path)
+	return c.forEach(path, func(_ int, s *Config) error {
+		if v := s.getValue(""); v != nil {
+			if _, ok := v.(map[string]any); !ok {
+				return fmt.Errorf("invalid type %T at config path %q, expected map", v, s.subPath)
+			}
 		}
+		return fn(s)
+	})
+}
+
+func (c *Config) forEach(path string, fn func(i int, c *Config) error) error {
+	if c.parent != nil {
+		return c.parent.forEach(c.fullPath(path), fn)
+	}
+	path = c.key(path)
+	// requires merged tree
+	node, err := c.findNode(c.All(), path)
+	if err != nil {
+		return err
 	}
-	// assuming the last sub-tree element is a slice
-	slice, ok := s[segs[len(segs)-1]].([]any)
+	slice, ok := node.([]any)
 	if !ok {
 		return fmt.Errorf("expected slice of values at path %q", path)
 	}
-	for i, v := range slice {
-		err := fn(&Config{
-			envPrefix: c.expandEnvKey(path + "." + strconv.Itoa(i)),
-			noEnv:     c.noEnv,
-			data:      v.(map[string]any),
-			merged:    v.(map[string]any),
-		})
-		if err != nil {
+	for i := range slice {
+		if err := fn(i, c.Sub(joinPath(path, strconv.Itoa(i)))); err != nil {
 			return err
 		}
 	}
@@ -713,12 +1003,7 @@ func (c *Config) ForEach(path string, fn func(c *Config) error) error {
 			if !strings.HasPrefix(v, prefix) {
 				continue
 			}
-			err := fn(&Config{
-				envPrefix: prefix,
-				data:      nil,
-				merged:    nil,
-			})
-			if err != nil {
+			if err := fn(more, c.Sub(joinPath(path, strconv.Itoa(more)))); err != nil {
 				return err
 			}
 			found = true
@@ -732,46 +1017,102 @@ func (c *Config) ForEach(path string, fn func(c *Config) error) error {
 	return nil
 }
 
-func (c *Config) Branch(path string) (*Config, error) {
-	// requires merged tree
-	s := c.All()
-	segs := strings.Split(path, ".") // path segments
-	for i, v := range segs {
-		if s == nil {
-			break
+// ForEachKey calls fn with the name and a view of every entry of the map
+// at path in sorted order. Entries must be maps.
+func (c *Config) ForEachKey(path string, fn func(name string, c *Config) error) error {
+	if c.parent != nil {
+		return c.parent.ForEachKey(c.fullPath(path), fn)
+	}
+	path = c.key(path)
+	node, err := c.findNode(c.All(), path)
+	if err != nil {
+		return err
+	}
+	m, ok := node.(map[string]any)
+	if !ok {
+		return fmt.Errorf("expected map of values at path %q", path)
+	}
+	for _, name := range childNames(m) {
+		key := formatPath(append(splitPath(path), name), nil)
+		if _, ok := m[name].(map[string]any); !ok {
+			return fmt.Errorf("invalid type %T at config path %q, expected map", m[name], key)
+		}
+		if err := fn(name, c.Sub(key)); err != nil {
+			return err
 		}
-		sub, ok := s[v]
-		if !ok {
-			return nil, fmt.Errorf("missing config path %q", path)
+	}
+	return nil
+}
+
+// ForEachInto decodes every element of the slice at path into a value of
+// type T and calls fn with its index. Elements may be maps bound to
+// structs as in Unmarshal or scalars.
+func ForEachInto[T any](c *Config, path string, fn func(i int, v T) error) error {
+	if c == nil {
+		c = config
+	}
+	return c.forEach(path, func(i int, s *Config) error {
+		val, err := s.getValueE("")
+		if err != nil {
+			return err
 		}
-		s, ok = sub.(map[string]any)
-		if !ok {
-			return nil, fmt.Errorf("invalid type %T at config path %q pos %d", sub, path, i)
+		var v T
+		if err := s.bind("", val, &v); err != nil {
+			return fmt.Errorf("config path %q: %v", s.subPath, err)
 		}
+		return fn(i, v)
+	})
+}
+
+func (c *Config) Branch(path string) (*Config, error) {
+	path = c.key(path)
+	// requires merged tree
+	node, err := c.findNode(c.All(), path)
+	if err != nil {
+		return nil, err
+	}
+	s, ok := node.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("invalid type %T at config path %q", node, path)
 	}
 	cp := make(map[string]any)
 	for n, v := range s {
 		cp[n] = v
 	}
 	branch := &Config{
-		envPrefix:  c.expandEnvKey(strings.Join(segs[:len(segs)-1], ".")),
-		branchName: path,
-		noEnv:      c.noEnv,
-		data:       cp,
-		merged:     cp,
+		envPrefix:      c.envPrefix,
+		keyPrefix:      joinPath(c.keyPrefix, path),
+		envMapper:      c.envMapper,
+		keyNorm:        c.keyNorm,
+		branchName:     path,
+		noEnv:          c.noEnv,
+		data:           cp,
+		merged:         cp,
+		specs:          c.specs,
+		secretKeys:     c.secretKeys,
+		secretPatterns: c.secretPatterns,
 	}
 	return branch, nil
 }
 
 func (c *Config) Args() []string {
 	args := make([]string, 0)
+	prefix := c.branchName
+	if c.parent != nil {
+		prefix = c.subPath
+	}
 	_ = walkTree(c.All(), "", func(key, val string) error {
-		if c.branchName != "" {
-			key = c.branchName + "." + key
+		full := key
+		if prefix != "" {
+			full = prefix + "." + key
+		}
+		if c.IsSecret(key) {
+			val = Redacted
 		}
 		if val != "" {
 			val = "=" + val
 		}
+		key = full
 		args = append(args, "-"+key+val)
 		return nil
 	})
@@ -780,39 +1121,62 @@ func (c *Config) Args() []string {
 
 func (c *Config) Unmarshal(path string, val any) error {
 	// requires merged tree
-	s := c.All()
-	for _, v := range strings.Split(path, ".") {
-		if s == nil {
-			break
-		}
-		sub, ok := s[v]
-		if !ok {
-			return fmt.Errorf("missing config path %q", path)
-		}
-		s, ok = sub.(map[string]any)
-		if !ok {
-			return fmt.Errorf("invalid type %T at config path %q", sub, path)
+	node, err := c.resolveNode(path)
+	if err != nil {
+		return err
+	}
+	if node == nil {
+		return fmt.Errorf("missing config path %q", c.key(path))
+	}
+	return c.bind(path, node, val)
+}
+
+// bind decodes node found at path into val using JSON rules. Type errors
+// name the file and line where the offending key is defined.
+func (c *Config) bind(path string, node, val any) error {
+	if c.parent != nil {
+		return c.parent.bind(c.fullPath(path), node, val)
+	}
+	if c.keyNorm != nil {
+		node = bindKeys(node, reflect.TypeOf(val), c.keyNorm)
+	}
+	buf, err := json.Marshal(node)
+	if err != nil {
+		return err
+	}
+	err = json.Unmarshal(buf, val)
+	var te *json.UnmarshalTypeError
+	if errors.As(err, &te) {
+		if pos, ok := c.findSource(path, te.Field); ok {
+			return fmt.Errorf("%w (defined at %s)", err, pos)
 		}
 	}
-	buf, _ := json.Marshal(s)
-	return json.Unmarshal(buf, val)
+	return err
 }
 
-var exp = regexp.MustCompile(`\${(.+?)}`)
+// findNode returns the sub-tree at path, or the whole tree when path
+// is empty.
+func (c *Config) findNode(tree map[string]any, path string) (any, error) {
+	if path == "" {
+		return tree, nil
+	}
+	node := c.getIn(tree, path)
+	if node == nil {
+		return nil, fmt.Errorf("missing config path %q", path)
+	}
+	return node, nil
+}
 
-// Resolves env/config variables embedded in a string using ${VAR}
-func (c *Config) Expand(s string) string {
-	for _, match := range exp.FindAllStringSubmatch(s, -1) {
-		if len(match) < 2 {
-			continue
-		}
-		path := strings.ToLower(match[1])
-		path = strings.Replace(path, "_", ".", -1)
-		val := c.getValue(path)
-		if val == nil {
-			continue
-		}
-		s = strings.Replace(s, match[0], toString(val), -1)
+// decodeJSON unmarshals buf into val and decodes numbers as json.Number
+// so that large integers do not lose precision.
+func decodeJSON(buf []byte, val any) error {
+	dec := json.NewDecoder(bytes.NewReader(buf))
+	dec.UseNumber()
+	if err := dec.Decode(val); err != nil {
+		return err
+	}
+	if dec.More() {
+		return fmt.Errorf("invalid data after top-level value")
 	}
-	return s
+	return nil
 }
@@ -6,9 +6,19 @@
 package config
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
 	"io/ioutil"
+	"log/slog"
+	"math"
 	"os"
+	"path/filepath"
 	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -123,6 +133,14 @@ func TestStringMapEnv(T *testing.T) {
 	if v, ok := c.GetStringMap(key)["three"]; !ok || v != "three" {
 		T.Errorf("invalid result: expected=%v got=%v (%[2]T)", "three", v)
 	}
+
+	// map keys from env are not split into paths
+	T.Setenv("TEST_LABELS_TEAM_NAME", "core")
+	c.Set("test.labels", map[string]any{"team_name": "x"})
+	got := c.GetStringMap("test.labels")
+	if exp := map[string]string{"team_name": "core"}; !reflect.DeepEqual(exp, got) {
+		T.Errorf("invalid result: expected=%v got=%v", exp, got)
+	}
 }
 
 func TestInt(T *testing.T) {
@@ -193,6 +211,87 @@ func TestInt64(T *testing.T) {
 	}
 }
 
+func TestIntSyntax(T *testing.T) {
+	c := NewConfig()
+	for _, tc := range []struct {
+		val any
+		exp int64
+	}{
+		{"0x1f", 31},
+		{"0o755", 493},
+		{"0", 0},
+		{"-0", 0},
+		{"0.0", 0},
+		{"0b101", 5},
+		{"1_000_000", 1000000},
+		{"-42", -42},
+		{"1e3", 1000},
+	} {
+		c.Set("test.int", tc.val)
+		if exp, got := tc.exp, c.GetInt64("test.int"); exp != got {
+			T.Errorf("%v: invalid result: expected=%v got=%v (%[3]T)", tc.val, exp, got)
+		}
+	}
+	// leading zeros may mean octal or decimal
+	for _, v := range []string{"0755", "0855", "-007", "00", "0_7", "01e3"} {
+		c.Set("test.int", v)
+		if _, err := c.GetInt64E("test.int"); err == nil || !strings.Contains(err.Error(), "ambiguous leading zero") {
+			T.Errorf("%s: expected leading zero error, got %v", v, err)
+		}
+		if _, err := c.GetUint64E("test.int"); err == nil {
+			T.Errorf("%s: expected leading zero error", v)
+		}
+		if got := c.GetBigInt("test.int"); got.Sign() != 0 {
+			T.Errorf("%s: invalid result: expected=0 got=%v", v, got)
+		}
+	}
+	c.Set("test.slice", "0x10,0b11,1_0")
+	if exp, got := []int{16, 3, 10}, c.GetIntSlice("test.slice"); !reflect.DeepEqual(exp, got) {
+		T.Errorf("invalid result: expected=%v got=%v (%[2]T)", exp, got)
+	}
+}
+
+func TestIntOverflow(T *testing.T) {
+	c := NewConfig()
+	c.Set("test.int", 300)
+	if _, err := c.GetInt8("test.int"); err == nil {
+		T.Errorf("expected overflow error for int8")
+	}
+	if v, err := c.GetInt16("test.int"); err != nil || v != 300 {
+		T.Errorf("invalid result: got=%v err=%v", v, err)
+	}
+	if _, err := c.GetUint8("test.int"); err == nil {
+		T.Errorf("expected overflow error for uint8")
+	}
+	c.Set("test.neg", -1.0)
+	if _, err := c.GetUint32("test.neg"); err == nil {
+		T.Errorf("expected range error for negative uint")
+	}
+	if exp, got := uint(0), c.GetUint("test.neg"); exp != got {
+		T.Errorf("invalid result: expected=%v got=%v (%[2]T)", exp, got)
+	}
+	c.Set("test.big", "0x1ffffffffffffffff")
+	if _, err := c.GetUint32("test.big"); err == nil || !strings.Contains(err.Error(), "out of range") {
+		T.Errorf("expected out of range error, got %v", err)
+	}
+	c.Set("test.frac", 1.5)
+	if _, err := c.GetInt32("test.frac"); err == nil {
+		T.Errorf("expected error for fractional value")
+	}
+	if v, err := c.GetIntE("test.frac"); err == nil || v != 0 || c.GetInt("test.frac") != 0 {
+		T.Errorf("expected error and zero for fractional value, got %v %v", v, err)
+	}
+	if _, err := c.GetUintE("test.neg"); err == nil {
+		T.Errorf("expected range error for negative uint")
+	}
+	if v, err := c.GetUint64E("test.big"); err == nil || !strings.Contains(err.Error(), "out of range") || v != 0 {
+		T.Errorf("expected out of range error, got %v %v", v, err)
+	}
+	if v, err := c.GetInt64E("test.int"); err != nil || v != 300 {
+		T.Errorf("invalid result: got=%v err=%v", v, err)
+	}
+}
+
 func TestFloat(T *testing.T) {
 	c := NewConfig()
 	key, val := "test.float", "1.1"
@@ -221,6 +320,104 @@ func TestDuration(T *testing.T) {
 	}
 }
 
+func TestByteSize(T *testing.T) {
+	c := NewConfig()
+	for _, tc := range []struct {
+		val string
+		exp ByteSize
+	}{
+		{"512MiB", 512 * MiB},
+		{"1.5GB", 1500 * MB},
+		{"64k", 64 * KiB},
+		{"100", 100},
+		{"2 kB", 2000},
+	} {
+		c.Set("test.size", tc.val)
+		if exp, got := tc.exp, c.GetByteSize("test.size"); exp != got {
+			T.Errorf("%s: invalid result: expected=%v got=%v (%[3]T)", tc.val, exp, got)
+		}
+		if v, err := ParseByteSize(tc.exp.String()); err != nil || v != tc.exp {
+			T.Errorf("%s: invalid roundtrip: expected=%v got=%v err=%v", tc.val, tc.exp, v, err)
+		}
+	}
+	if _, err := ParseByteSize("10XB"); err == nil {
+		T.Errorf("expected error for invalid unit")
+	}
+
+	// numbers and strings round the same way, negative sizes are 0
+	n := NewConfig()
+	if err := n.ReadConfig([]byte(`{"num": 1.5, "str": "1.5", "neg": -1.5, "negint": -2}`)); err != nil {
+		T.Fatal(err)
+	}
+	n.Set("float", -2.5)
+	n.Set("int", -3)
+	for path, exp := range map[string]ByteSize{"num": 2, "str": 2, "neg": 0, "negint": 0, "float": 0, "int": 0} {
+		if got := n.GetByteSize(path); exp != got {
+			T.Errorf("%s: invalid result: expected=%v got=%v", path, exp, got)
+		}
+	}
+	c.SetEnvPrefix("TESTPREFIX")
+	T.Setenv("TESTPREFIX_TEST_SIZE", "1GiB")
+	if exp, got := GiB, c.GetByteSize("test.size"); exp != got {
+		T.Errorf("invalid result: expected=%v got=%v (%[2]T)", exp, got)
+	}
+	var v struct {
+		Size ByteSize `json:"size"`
+	}
+	if err := c.Unmarshal("test", &v); err != nil {
+		T.Fatal(err)
+	}
+	if exp, got := GiB, v.Size; exp != got {
+		T.Errorf("invalid result: expected=%v got=%v (%[2]T)", exp, got)
+	}
+}
+
+func TestNet(T *testing.T) {
+	c := NewConfig()
+	c.Set("net.ip", "10.0.0.1")
+	c.Set("net.cidr", "10.0.0.0/8")
+	c.Set("net.allow", "10.0.0.0/8, 192.168.1.1,::1")
+	c.Set("net.listen", "127.0.0.1:8080")
+	c.Set("net.host", "example.com")
+	c.Set("net.url", "https://example.com/api")
+	c.Set("net.bad", "10.0.0.300")
+
+	if ip, err := c.GetIP("net.ip"); err != nil || ip.String() != "10.0.0.1" {
+		T.Errorf("invalid result: got=%v err=%v", ip, err)
+	}
+	if _, err := c.GetIP("net.bad"); err == nil {
+		T.Errorf("expected error for invalid IP")
+	}
+	if ip, err := c.GetIP("net.missing"); err != nil || ip != nil {
+		T.Errorf("invalid result for missing key: got=%v err=%v", ip, err)
+	}
+	if n, err := c.GetIPNet("net.cidr"); err != nil || n.String() != "10.0.0.0/8" {
+		T.Errorf("invalid result: got=%v err=%v", n, err)
+	}
+	pfx, err := c.GetPrefixSlice("net.allow")
+	if err != nil {
+		T.Fatal(err)
+	}
+	if exp, got := "[10.0.0.0/8 192.168.1.1/32 ::1/128]", fmt.Sprint(pfx); exp != got {
+		T.Errorf("invalid result: expected=%v got=%v", exp, got)
+	}
+	if ap, err := c.GetAddrPort("net.listen"); err != nil || ap.Port() != 8080 {
+		T.Errorf("invalid result: got=%v err=%v", ap, err)
+	}
+	if hp, err := c.GetHostPort("net.host", 443); err != nil || hp != "example.com:443" {
+		T.Errorf("invalid result: got=%v err=%v", hp, err)
+	}
+	if hp, err := c.GetHostPort("net.listen", 443); err != nil || hp != "127.0.0.1:8080" {
+		T.Errorf("invalid result: got=%v err=%v", hp, err)
+	}
+	if u, err := c.GetURL("net.url"); err != nil || u.Host != "example.com" {
+		T.Errorf("invalid result: got=%v err=%v", u, err)
+	}
+	if _, err := c.GetURL("net.host"); err == nil {
+		T.Errorf("expected error for URL without scheme")
+	}
+}
+
 func TestTime(T *testing.T) {
 	c := NewConfig()
 	key, val := "test.time", "2023-02-01"
@@ -498,3 +695,1509 @@ func TestArgsEnv(T *testing.T) {
 	}
 
 }
+
+func TestEnum(T *testing.T) {
+	c := NewConfig()
+	levels := []string{"debug", "info", "warn", "error"}
+	c.SetEnum("log.level", levels, "info")
+	if v, err := c.GetEnum("log.level"); err != nil || v != "info" {
+		T.Errorf("invalid default: got=%v err=%v", v, err)
+	}
+	c.Set("log.level", "WARN")
+	if v, err := c.GetEnum("log.level"); err != nil || v != "warn" {
+		T.Errorf("invalid result: got=%v err=%v", v, err)
+	}
+	if v, err := c.GetEnum("log.level", "Warn", "Error"); err != nil || v != "Warn" {
+		T.Errorf("invalid result: got=%v err=%v", v, err)
+	}
+	if err := c.Validate(); err != nil {
+		T.Errorf("unexpected validation error: %v", err)
+	}
+	c.Set("log.level", "verbose")
+	if _, err := c.GetEnum("log.level"); err == nil {
+		T.Errorf("expected error for invalid enum value")
+	}
+	if err := c.Validate(); err == nil {
+		T.Errorf("expected validation error")
+	}
+	if help := c.Help(); !strings.Contains(help, "one of: debug, info, warn, error") {
+		T.Errorf("missing enum values in help text:\n%s", help)
+	}
+}
+
+func TestFullPrecision(T *testing.T) {
+	c := NewConfig()
+	if err := c.ReadConfig([]byte(`{"id": 18446744073709551615, "amount": 123456789012345678901234567890, "price": 0.1000000000000000000001}`)); err != nil {
+		T.Fatal(err)
+	}
+	if exp, got := uint64(math.MaxUint64), c.GetUint64("id"); exp != got {
+		T.Errorf("invalid result: expected=%v got=%v (%[2]T)", exp, got)
+	}
+	if exp, got := "18446744073709551615", fmt.Sprint(c.All()["id"]); exp != got {
+		T.Errorf("invalid merged result: expected=%v got=%v", exp, got)
+	}
+	if exp, got := "123456789012345678901234567890", c.GetBigInt("amount").String(); exp != got {
+		T.Errorf("invalid result: expected=%v got=%v", exp, got)
+	}
+	if exp, got := "0.1000000000000000000001", c.GetBigFloat("price").Text('f', 22); exp != got {
+		T.Errorf("invalid result: expected=%v got=%v", exp, got)
+	}
+	c.Set("hex", "0xff")
+	if exp, got := int64(255), c.GetBigInt("hex").Int64(); exp != got {
+		T.Errorf("invalid result: expected=%v got=%v", exp, got)
+	}
+
+	// fractional numbers are truncated
+	if err := c.ReadConfig([]byte(`{"timeout": 1.5, "since": 1.5}`)); err != nil {
+		T.Fatal(err)
+	}
+	if exp, got := time.Duration(1), c.GetDuration("timeout"); exp != got {
+		T.Errorf("invalid result: expected=%v got=%v", exp, got)
+	}
+	if exp, got := int64(1), c.GetTime("since").Unix(); exp != got {
+		T.Errorf("invalid result: expected=%v got=%v", exp, got)
+	}
+}
+
+var testschema = `{
+  "$schema": "https://json-schema.org/draft/2020-12/schema",
+  "type": "object",
+  "required": ["db"],
+  "properties": {
+    "db": {
+      "type": "object",
+      "required": ["host"],
+      "additionalProperties": false,
+      "properties": {
+        "host": {"type": "string", "pattern": "^[a-z.]+$"},
+        "port": {"$ref": "#/$defs/port"},
+        "mode": {"enum": ["ro", "rw"]}
+      }
+    }
+  },
+  "$defs": {
+    "port": {"type": "integer", "minimum": 1, "maximum": 65535}
+  }
+}`
+
+func TestSchema(T *testing.T) {
+	c := NewConfig()
+	if err := c.ReadConfig([]byte(`{"db": {"host": "localhost", "port": 5432, "mode": "rw"}}`)); err != nil {
+		T.Fatal(err)
+	}
+	if err := c.ValidateSchema([]byte(testschema)); err != nil {
+		T.Errorf("unexpected error: %v", err)
+	}
+	c.Set("db.port", 70000)
+	c.Set("db.mode", "wo")
+	c.Set("db.user", "admin")
+	err := c.ValidateSchema([]byte(testschema))
+	var errs SchemaErrors
+	if !errors.As(err, &errs) {
+		T.Fatalf("expected schema errors, got %v", err)
+	}
+	paths := make([]string, 0)
+	for _, e := range errs {
+		paths = append(paths, e.Path+":"+e.Keyword)
+	}
+	sort.Strings(paths)
+	if exp, got := []string{"db.mode:enum", "db.port:maximum", "db.user:additionalProperties"}, paths; !reflect.DeepEqual(exp, got) {
+		T.Errorf("invalid result: expected=%v got=%v", exp, got)
+	}
+
+	// env strings are accepted for numeric types
+	c = NewConfig()
+	c.SetEnvPrefix("TESTPREFIX")
+	T.Setenv("TESTPREFIX_DB_PORT", "8080")
+	if err := c.SetSchema([]byte(testschema)); err != nil {
+		T.Fatal(err)
+	}
+	if err := c.ReadConfig([]byte(`{"db": {"host": "localhost"}}`)); err != nil {
+		T.Errorf("unexpected error: %v", err)
+	}
+	// invalid configs are rejected and not loaded
+	if err := c.ReadConfig([]byte(`{"db": {"host": "Local_Host"}}`)); err == nil {
+		T.Errorf("expected validation error")
+	}
+	if exp, got := "localhost", c.GetString("db.host"); exp != got {
+		T.Errorf("invalid result: expected=%v got=%v (%[2]T)", exp, got)
+	}
+}
+
+func TestDefine(T *testing.T) {
+	c := NewConfig()
+	c.SetEnvPrefix("TESTPREFIX")
+	c.Define("db.host", Spec{Type: TypeString, Required: true, Description: "database host"})
+	c.Define("db.port", Spec{Type: TypeInt, Default: 5432, Min: 1, Max: 65535})
+	c.Define("db.timeout", Spec{Type: TypeDuration, Default: "5s", Max: "1m"})
+	c.Define("db.name", Spec{Type: TypeString, Pattern: "^[a-z_]+$"})
+	c.Define("db.password", Spec{Type: TypeString, Secret: true, Default: "hunter2"})
+	c.Define("db.pool", Spec{Type: TypeInt, Deprecated: "use db.max_conns"})
+
+	if exp, got := int64(5432), c.GetInt64("db.port"); exp != got {
+		T.Errorf("invalid default: expected=%v got=%v (%[2]T)", exp, got)
+	}
+	if err := c.Validate(); err == nil || !strings.Contains(err.Error(), `missing required config key "db.host"`) {
+		T.Errorf("expected missing required key error, got %v", err)
+	}
+	c.Set("db.host", "localhost")
+	c.Set("db.port", 70000)
+	c.Set("db.name", "Bad-Name")
+	T.Setenv("TESTPREFIX_DB_TIMEOUT", "2m")
+	err := c.Validate()
+	if err == nil {
+		T.Fatalf("expected validation errors")
+	}
+	for _, s := range []string{
+		`"db.port": value 70000 exceeds maximum 65535`,
+		`"db.timeout": value 2m0s exceeds maximum 1m`,
+		`"db.name": value "Bad-Name" does not match pattern`,
+	} {
+		if !strings.Contains(err.Error(), s) {
+			T.Errorf("missing validation error %q in %v", s, err)
+		}
+	}
+	if n := len(c.Deprecations()); n != 0 {
+		T.Errorf("unexpected deprecations %v", c.Deprecations())
+	}
+	c.Set("db.pool", 10)
+	if n := len(c.Deprecations()); n != 1 {
+		T.Errorf("expected deprecation, got %v", c.Deprecations())
+	}
+	help := c.Help()
+	for _, s := range []string{"db.host <string> (required)", "database host", "env: TESTPREFIX_DB_PORT", "max: 65535"} {
+		if !strings.Contains(help, s) {
+			T.Errorf("missing %q in help text:\n%s", s, help)
+		}
+	}
+	if strings.Contains(help, "hunter2") {
+		T.Errorf("secret default in help text:\n%s", help)
+	}
+	c.SetDefault("api.token", "tok")
+	c.SetDefault("db.dsn", "postgres://x")
+	c.SetSecret("db.dsn", true)
+	if help := c.Help(); !strings.Contains(help, "api.token") || strings.Contains(help, "tok\n") || strings.Contains(help, "postgres://x") {
+		T.Errorf("secret default in help text:\n%s", help)
+	}
+
+	// untyped limits apply to plain ints
+	c = NewConfig()
+	if err := c.Define("workers", Spec{Min: 1, Max: 8}); err != nil {
+		T.Fatal(err)
+	}
+	c.Set("workers", 0)
+	if err := c.Validate(); err == nil || !strings.Contains(err.Error(), "less than minimum 1") {
+		T.Errorf("expected minimum error, got %v", err)
+	}
+	if err := c.Define("db.name", Spec{Pattern: "[a-"}); err == nil {
+		T.Errorf("expected invalid pattern error")
+	}
+	if _, ok := c.Spec("db.name"); ok {
+		T.Errorf("invalid spec must not be registered")
+	}
+	if err := c.Define(`servers[0]["port"]`, Spec{Type: TypeInt}); err != nil {
+		T.Fatal(err)
+	}
+	if _, ok := c.Spec("servers.0.port"); !ok {
+		T.Errorf("missing spec for bracket key")
+	}
+}
+
+func TestUnknownKeys(T *testing.T) {
+	c := NewConfig()
+	c.SetEnvPrefix("TESTPREFIX")
+	c.SetDefault("database.host", "localhost")
+	c.SetDefault("database.port", 5432)
+	c.Define("labels", Spec{Type: TypeMap})
+	T.Setenv("TESTPREFIX_DATABASE_HOTS", "db")
+	T.Setenv("TESTPREFIX_LABELS_APP", "x")
+	if err := c.ReadConfig([]byte(`{"databse": {"host": "x"}, "database": {"prot": 1}, "labels": {"a": "b"}, "zzz": 1}`)); err != nil {
+		T.Fatal(err)
+	}
+	got := make([]string, 0)
+	for _, k := range c.UnknownKeys() {
+		got = append(got, k.Key+">"+k.Suggestion)
+	}
+	exp := []string{
+		"database.prot>database.port",
+		"databse>database",
+		"zzz>",
+		"TESTPREFIX_DATABASE_HOTS>TESTPREFIX_DATABASE_HOST",
+	}
+	if !reflect.DeepEqual(exp, got) {
+		T.Errorf("invalid result: expected=%v got=%v", exp, got)
+	}
+
+	c = NewConfig()
+	c.SetDefault("database.host", "localhost")
+	c.SetStrict(true)
+	err := c.ReadConfig([]byte(`{"databse": {"host": "x"}}`))
+	if err == nil || !strings.Contains(err.Error(), `did you mean "database"`) {
+		T.Errorf("expected unknown key error, got %v", err)
+	}
+	if c.Has("databse.host") {
+		T.Errorf("invalid config must not be loaded")
+	}
+
+	// only maps and slices have unregistered children
+	c = NewConfig()
+	c.SetEnvPrefix("TESTSCALAR")
+	c.SetDefault("log", "info")
+	c.SetDefault("tags", []string{"a"})
+	T.Setenv("TESTSCALAR_LOG_LEVLE", "debug")
+	T.Setenv("TESTSCALAR_TAGS_0", "b")
+	if err := c.ReadConfig([]byte(`{"log": {"levle": 1}}`)); err != nil {
+		T.Fatal(err)
+	}
+	got = got[:0]
+	for _, k := range c.UnknownKeys() {
+		got = append(got, k.Key)
+	}
+	if exp := []string{"log.levle", "TESTSCALAR_LOG_LEVLE"}; !reflect.DeepEqual(exp, got) {
+		T.Errorf("invalid result: expected=%v got=%v", exp, got)
+	}
+}
+
+func TestExpand(T *testing.T) {
+	c := NewConfig()
+	c.Set("db.host", "localhost")
+	c.Set("db.max_conns", 10)
+	c.Set("db.empty", "")
+	T.Setenv("TEST_EXPAND_USER", "admin")
+	for _, tc := range []struct {
+		in, out string
+		fail    bool
+	}{
+		{"host=${DB_HOST}", "host=localhost", false},
+		{"${cfg:db.max_conns}", "10", false},
+		{"${env:TEST_EXPAND_USER}@${cfg:db.host}", "admin@localhost", false},
+		{"${db.port:-5432}", "5432", false},
+		{"${db.empty:-${cfg:db.host}}", "localhost", false},
+		{"${db.host:+set}${db.port:+set}", "set", false},
+		{"$${db.host} costs $5", "${db.host} costs $5", false},
+		{"${db.port}", "${db.port}", true},
+		{"${db.port:?db port is required}", "${db.port:?db port is required}", true},
+	} {
+		if got := c.Expand(tc.in); got != tc.out {
+			T.Errorf("%s: invalid result: expected=%v got=%v", tc.in, tc.out, got)
+		}
+		_, err := c.ExpandE(tc.in)
+		if tc.fail != (err != nil) {
+			T.Errorf("%s: unexpected error result %v", tc.in, err)
+		}
+	}
+	if _, err := c.ExpandE("${db.port:?db port is required}"); err == nil || !strings.Contains(err.Error(), "db port is required") {
+		T.Errorf("expected custom error message, got %v", err)
+	}
+}
+
+func TestInterpolate(T *testing.T) {
+	c := NewConfig()
+	c.SetInterpolate(true)
+	if err := c.ReadConfig([]byte(`{
+		"db": {
+			"user": "admin",
+			"host": "${db.server}",
+			"server": "localhost",
+			"port": 5432,
+			"max_conns": 10,
+			"dsn": "postgres://${db.user}@${db.host}:${db.port}?max=${db.max_conns}",
+			"price": "$${db.port}"
+		}
+	}`)); err != nil {
+		T.Fatal(err)
+	}
+	if exp, got := "postgres://admin@localhost:5432?max=10", c.GetString("db.dsn"); exp != got {
+		T.Errorf("invalid result: expected=%v got=%v", exp, got)
+	}
+	if exp, got := "${db.port}", c.GetString("db.price"); exp != got {
+		T.Errorf("invalid result: expected=%v got=%v", exp, got)
+	}
+	var v struct {
+		DSN string `json:"dsn"`
+	}
+	if err := c.Unmarshal("db", &v); err != nil {
+		T.Fatal(err)
+	}
+	if exp, got := "postgres://admin@localhost:5432?max=10", v.DSN; exp != got {
+		T.Errorf("invalid result: expected=%v got=%v", exp, got)
+	}
+	db := c.All()["db"].(map[string]any)
+	if exp, got := "localhost", db["host"]; exp != got {
+		T.Errorf("invalid result: expected=%v got=%v", exp, got)
+	}
+
+	// concurrent readers do not share resolution state
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				if got := c.GetString("db.dsn"); got != "postgres://admin@localhost:5432?max=10" {
+					T.Errorf("invalid concurrent result: %v", got)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	c.Set("a", "${b}")
+	c.Set("b", "x${a}")
+	if err := c.Validate(); err == nil || strings.Count(err.Error(), "reference cycle") != 1 {
+		T.Errorf("expected one reference cycle error, got %v", err)
+	}
+	// only the bound sub-tree is resolved
+	if err := c.Unmarshal("db", &v); err != nil {
+		T.Errorf("unexpected error: %v", err)
+	}
+	var all map[string]any
+	if err := c.Unmarshal("", &all); err == nil || strings.Count(err.Error(), "reference cycle") != 1 {
+		T.Errorf("expected one reference cycle error, got %v", err)
+	}
+}
+
+func TestSecrets(T *testing.T) {
+	dir := T.TempDir()
+	name := filepath.Join(dir, "db_password")
+	if err := os.WriteFile(name, []byte("s3cret\n"), 0400); err != nil {
+		T.Fatal(err)
+	}
+	T.Setenv("TEST_SECRET_TOKEN", "t0ken")
+	c := NewConfig()
+	if err := c.ReadConfig([]byte(`{
+		"db": {
+			"password": "${file:` + name + `}",
+			"token": "${env:TEST_SECRET_TOKEN}",
+			"key": "${base64:aGVsbG8=}",
+			"plain": "${db.token}"
+		}
+	}`)); err != nil {
+		T.Fatal(err)
+	}
+
+	// references are literal unless enabled
+	if exp, got := "${base64:aGVsbG8=}", c.GetString("db.key"); exp != got {
+		T.Errorf("invalid result: expected=%v got=%v", exp, got)
+	}
+	if db := c.All()["db"].(map[string]any); db["key"] != "${base64:aGVsbG8=}" {
+		T.Errorf("invalid result: got=%v", db["key"])
+	}
+	c.SetResolveSecrets(true)
+	for key, exp := range map[string]string{
+		"db.password": "s3cret",
+		"db.token":    "t0ken",
+		"db.key":      "hello",
+		"db.plain":    "${db.token}",
+	} {
+		if got := c.GetString(key); exp != got {
+			T.Errorf("%s: invalid result: expected=%v got=%v", key, exp, got)
+		}
+	}
+	var v struct {
+		Password string `json:"password"`
+	}
+	if err := c.Unmarshal("db", &v); err != nil || v.Password != "s3cret" {
+		T.Errorf("invalid result: got=%v err=%v", v.Password, err)
+	}
+
+	// resolved values are cached
+	calls := 0
+	c.RegisterSecretResolver("vault", SecretResolverFunc(func(ref string) (string, error) {
+		calls++
+		return "v-" + ref, nil
+	}))
+	c.Set("db.vault", "${vault:db/pass}")
+	for i := 0; i < 2; i++ {
+		if exp, got := "v-db/pass", c.GetString("db.vault"); exp != got {
+			T.Errorf("invalid result: expected=%v got=%v", exp, got)
+		}
+	}
+	if calls != 1 {
+		T.Errorf("expected cached secret, resolver called %d times", calls)
+	}
+	c.Set("db.missing", "${file:"+filepath.Join(dir, "missing")+"}")
+	if _, err := c.allE(); err == nil {
+		T.Errorf("expected error for missing secret file")
+	}
+}
+
+func TestEncrypt(T *testing.T) {
+	key, err := NewEncryptionKey()
+	if err != nil {
+		T.Fatal(err)
+	}
+	c := NewConfig()
+	if _, err := c.EncryptValue("x", "x"); !errors.Is(err, ErrNoEncryptionKey) {
+		T.Errorf("expected missing key error, got %v", err)
+	}
+	c.SetEnvPrefix("TESTPREFIX")
+	T.Setenv("TESTPREFIX_CONFIG_KEY", key)
+	enc, err := c.EncryptValue("api_key", "api-secret")
+	if err != nil {
+		T.Fatal(err)
+	}
+	if !strings.HasPrefix(enc, EncPrefix) {
+		T.Errorf("invalid ciphertext %q", enc)
+	}
+	if err := c.ReadConfig([]byte(`{"api_key": "` + enc + `", "db": {"password": "hunter2"}}`)); err != nil {
+		T.Fatal(err)
+	}
+	if exp, got := "api-secret", c.GetString("api_key"); exp != got {
+		T.Errorf("invalid result: expected=%v got=%v", exp, got)
+	}
+	if err := c.Encrypt("db.password"); err != nil {
+		T.Fatal(err)
+	}
+	if s := toString(getTree(c.data, "db.password")); !strings.HasPrefix(s, EncPrefix) {
+		T.Errorf("value not encrypted: %q", s)
+	}
+	if exp, got := "hunter2", c.GetString("db.password"); exp != got {
+		T.Errorf("invalid result: expected=%v got=%v", exp, got)
+	}
+
+	// the key env variable is not config data
+	if all := fmt.Sprint(c.All()); strings.Contains(all, key) {
+		T.Errorf("encryption key in merged config: %s", all)
+	}
+	c.SetDefault("api_key", "")
+	c.SetDefault("db.password", "")
+	T.Setenv("TESTPREFIX_CONFIG_KEY_FILE", "/nonexistent")
+	if keys := c.UnknownKeys(); len(keys) != 0 {
+		T.Errorf("unexpected unknown keys %v", keys)
+	}
+
+	// ciphertexts are bound to their key
+	c.Set("other", enc)
+	if err := c.Validate(); err == nil || !strings.Contains(err.Error(), `"other"`) {
+		T.Errorf("expected decryption error for moved value, got %v", err)
+	}
+
+	// wrong key fails
+	other, _ := NewEncryptionKey()
+	c2 := NewConfig()
+	if err := c2.SetEncryptionKey([]byte(other)); err != nil {
+		T.Fatal(err)
+	}
+	c2.Set("api_key", enc)
+	if _, err := c2.allE(); err == nil {
+		T.Errorf("expected decryption error with wrong key")
+	}
+}
+
+func TestRedact(T *testing.T) {
+	c := NewConfig()
+	c.SetEnvPrefix("TESTPREFIX")
+	c.Define("db.dsn", Spec{Type: TypeString, Secret: true})
+	c.SetSecret("auth", true)
+	T.Setenv("TESTPREFIX_DB_PASSWORD", "envpass")
+	if err := c.ReadConfig([]byte(`{
+		"db": {"host": "localhost", "dsn": "postgres://u:p@h", "api_key": "k"},
+		"auth": {"client_id": "x", "nested": {"a": "b"}}
+	}`)); err != nil {
+		T.Fatal(err)
+	}
+	for _, s := range []string{c.String(), c.Dump(), fmt.Sprint(c), strings.Join(c.Args(), " ")} {
+		for _, secret := range []string{"envpass", "postgres://", `"k"`, "client_id=x", `"x"`} {
+			if strings.Contains(s, secret) {
+				T.Errorf("secret %q leaked in %s", secret, s)
+			}
+		}
+		if !strings.Contains(s, Redacted) || !strings.Contains(s, "localhost") {
+			T.Errorf("invalid redacted output %s", s)
+		}
+	}
+	if exp, got := "envpass", c.GetString("db.password"); exp != got {
+		T.Errorf("invalid result: expected=%v got=%v", exp, got)
+	}
+	if exp, got := "db.password = [REDACTED] (from env TESTPREFIX_DB_PASSWORD)", c.Explain("db.password"); exp != got {
+		T.Errorf("invalid result: expected=%v got=%v", exp, got)
+	}
+	if exp, got := "db.host = localhost (from config)", c.Explain("db.host"); exp != got {
+		T.Errorf("invalid result: expected=%v got=%v", exp, got)
+	}
+	var buf bytes.Buffer
+	slog.New(slog.NewTextHandler(&buf, nil)).Info("config", "cfg", c)
+	if s := buf.String(); strings.Contains(s, "envpass") || !strings.Contains(s, "cfg.db.host=localhost") {
+		T.Errorf("invalid log output %s", s)
+	}
+	c.SetSecretPatterns()
+	if c.IsSecret("db.password") || !c.IsSecret("db.dsn") {
+		T.Errorf("invalid secret detection without patterns")
+	}
+}
+
+func TestEnvKeyMapper(T *testing.T) {
+	c := NewConfig()
+	c.SetEnvPrefix("TESTPREFIX")
+	c.SetDefault("db.max_conns", 5)
+	c.Define("db.read_replicas", Spec{Type: TypeMap})
+	T.Setenv("TESTPREFIX_DB_MAX_CONNS", "10")
+	T.Setenv("TESTPREFIX_DB_READ_REPLICAS_EU_WEST", "eu")
+	db := c.All()["db"].(map[string]any)
+	if exp, got := "10", db["max_conns"]; exp != got {
+		T.Errorf("invalid result: expected=%v got=%v in %v", exp, got, db)
+	}
+	if exp, got := "eu", c.GetString("db.read_replicas.eu.west"); exp != got {
+		T.Errorf("invalid result: expected=%v got=%v", exp, got)
+	}
+	if _, ok := db["max"]; ok {
+		T.Errorf("unexpected key db.max in %v", db)
+	}
+
+	c = NewConfig()
+	c.SetEnvPrefix("TESTPREFIX")
+	c.SetEnvKeyMapper(NestedEnvMapper{})
+	T.Setenv("TESTPREFIX_DB__POOL_SIZE", "7")
+	if exp, got := int64(7), c.GetInt64("db.pool_size"); exp != got {
+		T.Errorf("invalid result: expected=%v got=%v", exp, got)
+	}
+	db = c.All()["db"].(map[string]any)
+	if exp, got := "7", db["pool_size"]; exp != got {
+		T.Errorf("invalid result: expected=%v got=%v in %v", exp, got, db)
+	}
+	if exp, got := "TESTPREFIX_DB__POOL_SIZE", c.expandEnvKey("db.pool_size"); exp != got {
+		T.Errorf("invalid result: expected=%v got=%v", exp, got)
+	}
+}
+
+func TestSliceOverride(T *testing.T) {
+	c := NewConfig()
+	if err := c.ReadConfig([]byte(`{"servers": [{"host": "a", "port": 1}, {"host": "b", "port": 2}], "tags": ["x", "y"]}`)); err != nil {
+		T.Fatal(err)
+	}
+	c.Set("servers.1.host", "bb")
+	c.Set("servers.2", map[string]any{"host": "c"})
+	c.Set("servers.3.host", "d")
+	c.Set("servers.9.host", "ignored")
+	c.Set("tags.0", "xx")
+	for key, exp := range map[string]string{
+		"servers.0.host": "a",
+		"servers.1.host": "bb",
+		"servers.1.port": "2",
+		"servers.2.host": "c",
+		"servers.3.host": "d",
+		"tags.0":         "xx",
+	} {
+		if got := c.GetString(key); exp != got {
+			T.Errorf("%s: invalid result: expected=%v got=%v", key, exp, got)
+		}
+	}
+	if c.Has("servers.9.host") {
+		T.Errorf("unexpected out of range element")
+	}
+
+	c = NewConfig()
+	c.SetEnvPrefix("TESTPREFIX")
+	T.Setenv("TESTPREFIX_SERVERS_0_HOST", "aa")
+	T.Setenv("TESTPREFIX_SERVERS_1_HOST", "b")
+	T.Setenv("TESTPREFIX_SERVERS_5_HOST", "ignored")
+	if err := c.ReadConfig([]byte(`{"servers": [{"host": "a", "port": 1}]}`)); err != nil {
+		T.Fatal(err)
+	}
+	servers := c.All()["servers"].([]any)
+	if exp, got := 2, len(servers); exp != got {
+		T.Fatalf("invalid result: expected=%v got=%v (%v)", exp, got, servers)
+	}
+	if exp, got := "aa", servers[0].(map[string]any)["host"]; exp != got {
+		T.Errorf("invalid result: expected=%v got=%v", exp, got)
+	}
+	if exp, got := "b", servers[1].(map[string]any)["host"]; exp != got {
+		T.Errorf("invalid result: expected=%v got=%v", exp, got)
+	}
+
+	c = NewConfig()
+	c.SetEnvPrefix("TESTPREFIX")
+	c.Define("backends", Spec{Type: TypeSlice})
+	T.Setenv("TESTPREFIX_BACKENDS", `[{"host":"a"},{"host":"b"}]`)
+	T.Setenv("TESTPREFIX_BACKENDS_1_PORT", "80")
+	if exp, got := "b", c.GetString("backends.1.host"); exp != got {
+		T.Errorf("invalid result: expected=%v got=%v", exp, got)
+	}
+	if exp, got := int64(80), c.GetInt64("backends.1.port"); exp != got {
+		T.Errorf("invalid result: expected=%v got=%v", exp, got)
+	}
+	backends := c.All()["backends"].([]any)
+	if exp, got := "80", backends[1].(map[string]any)["port"]; exp != got {
+		T.Errorf("invalid result: expected=%v got=%v", exp, got)
+	}
+
+	// JSON-like values of other keys are strings
+	T.Setenv("TESTPREFIX_PASSWORD", "[1]")
+	c.SetDefault("tags", []string{})
+	T.Setenv("TESTPREFIX_TAGS", `["a","b"]`)
+	if exp, got := "[1]", c.GetString("password"); exp != got {
+		T.Errorf("invalid result: expected=%v got=%v", exp, got)
+	}
+	if exp, got := "[1]", c.All()["password"]; exp != got {
+		T.Errorf("invalid result: expected=%v got=%v", exp, got)
+	}
+	if exp, got := []string{"a", "b"}, c.GetStringSlice("tags"); !reflect.DeepEqual(exp, got) {
+		T.Errorf("invalid result: expected=%v got=%v", exp, got)
+	}
+}
+
+func TestPath(T *testing.T) {
+	for path, exp := range map[string][]string{
+		"db.host":                          {"db", "host"},
+		"servers[2].host":                  {"servers", "2", "host"},
+		"servers.2.host":                   {"servers", "2", "host"},
+		`labels["app.kubernetes.io/name"]`: {"labels", "app.kubernetes.io/name"},
+		`labels['a]b'].x`:                  {"labels", "a]b", "x"},
+		"matrix[1][0]":                     {"matrix", "1", "0"},
+		"servers[*].host":                  {"servers", "*", "host"},
+		"/servers/1/host":                  {"servers", "1", "host"},
+		"/a~1b/c~0d":                       {"a/b", "c~d"},
+	} {
+		segs, err := ParsePath(path)
+		if err != nil {
+			T.Errorf("%s: unexpected error: %v", path, err)
+			continue
+		}
+		if !reflect.DeepEqual(exp, segs) {
+			T.Errorf("%s: invalid result: expected=%v got=%v", path, exp, segs)
+		}
+	}
+	for _, path := range []string{"a[", "a[x]", "a[-1]", `a["b`, `a["b"c]`, "a[0]b"} {
+		if _, err := ParsePath(path); err == nil {
+			T.Errorf("%s: expected error", path)
+		}
+	}
+
+	c := NewConfig()
+	if err := c.ReadConfig([]byte(`{
+		"servers": [{"host": "a"}, {"host": "b"}, {"host": "c"}],
+		"labels": {"app.kubernetes.io/name": "web", "tier": "front"}
+	}`)); err != nil {
+		T.Fatal(err)
+	}
+	for path, exp := range map[string]string{
+		"servers[2].host":                  "c",
+		"servers.2.host":                   "c",
+		"/servers/1/host":                  "b",
+		`labels["app.kubernetes.io/name"]`: "web",
+		`labels['tier']`:                   "front",
+	} {
+		if got := c.GetString(path); exp != got {
+			T.Errorf("%s: invalid result: expected=%v got=%v", path, exp, got)
+		}
+	}
+	c.Set("servers[0].host", "aa")
+	if got := c.GetString("servers.0.host"); got != "aa" {
+		T.Errorf("invalid result: expected=%v got=%v", "aa", got)
+	}
+	c.SetDefault("servers[0].port", 80)
+	if got := c.GetInt("servers.0.port"); got != 80 {
+		T.Errorf("invalid result: expected=%v got=%v", 80, got)
+	}
+	b, err := c.Branch("servers[1]")
+	if err != nil {
+		T.Fatal(err)
+	}
+	if got := b.GetString("host"); got != "b" {
+		T.Errorf("invalid result: expected=%v got=%v", "b", got)
+	}
+
+	m, err := c.Query("servers[*].host")
+	if err != nil {
+		T.Fatal(err)
+	}
+	exp := []Match{{"servers[0].host", "aa"}, {"servers[1].host", "b"}, {"servers[2].host", "c"}}
+	if !reflect.DeepEqual(exp, m) {
+		T.Errorf("invalid result: expected=%v got=%v", exp, m)
+	}
+	m, err = c.Query("labels.*")
+	if err != nil {
+		T.Fatal(err)
+	}
+	exp = []Match{{`labels["app.kubernetes.io/name"]`, "web"}, {"labels.tier", "front"}}
+	if !reflect.DeepEqual(exp, m) {
+		T.Errorf("invalid result: expected=%v got=%v", exp, m)
+	}
+}
+
+func TestWalk(T *testing.T) {
+	c := NewConfig()
+	if err := c.ReadConfig([]byte(`{
+		"db": {"host": "localhost", "port": 5432, "tls": true},
+		"servers": [{"host": "a"}, {"host": "b"}],
+		"tags": []
+	}`)); err != nil {
+		T.Fatal(err)
+	}
+	keys := c.Keys("")
+	exp := []string{"db.host", "db.port", "db.tls", "servers[0].host", "servers[1].host"}
+	if !reflect.DeepEqual(exp, keys) {
+		T.Errorf("invalid result: expected=%v got=%v", exp, keys)
+	}
+	keys = c.Keys("servers")
+	exp = []string{"servers[0].host", "servers[1].host"}
+	if !reflect.DeepEqual(exp, keys) {
+		T.Errorf("invalid result: expected=%v got=%v", exp, keys)
+	}
+	keys = c.Keys("db.port")
+	exp = []string{"db.port"}
+	if !reflect.DeepEqual(exp, keys) {
+		T.Errorf("invalid result: expected=%v got=%v", exp, keys)
+	}
+	if got := c.Children(""); !reflect.DeepEqual([]string{"db", "servers", "tags"}, got) {
+		T.Errorf("invalid result: got=%v", got)
+	}
+	if got := c.Children("servers"); !reflect.DeepEqual([]string{"0", "1"}, got) {
+		T.Errorf("invalid result: got=%v", got)
+	}
+	if got := c.Len("servers"); got != 2 {
+		T.Errorf("invalid result: expected=%v got=%v", 2, got)
+	}
+	for path, exp := range map[string]ValueKind{
+		"db":         KindMap,
+		"db.host":    KindString,
+		"db.port":    KindNumber,
+		"db.tls":     KindBool,
+		"servers":    KindSlice,
+		"tags":       KindSlice,
+		"db.missing": KindInvalid,
+	} {
+		if got := c.Kind(path); got != exp {
+			T.Errorf("%s: invalid result: expected=%v got=%v", path, exp, got)
+		}
+	}
+
+	paths := make([]string, 0)
+	err := c.Walk(func(path string, v Value) error {
+		paths = append(paths, path+":"+v.Kind().String())
+		if path == "db" {
+			return SkipBranch
+		}
+		return nil
+	})
+	if err != nil {
+		T.Fatal(err)
+	}
+	exp = []string{"db:map", "servers:slice", "servers[0]:map", "servers[0].host:string",
+		"servers[1]:map", "servers[1].host:string", "tags:slice"}
+	if !reflect.DeepEqual(exp, paths) {
+		T.Errorf("invalid result: expected=%v got=%v", exp, paths)
+	}
+}
+
+func TestLookup(T *testing.T) {
+	c := NewConfig()
+	c.SetEnvPrefix("TESTLOOKUP")
+	c.SetDefault("db.host", "localhost")
+	c.SetDefault("db.port", 5432)
+	c.SetDefault("log.level", "info")
+	c.SetDefault("cache.size", 10)
+	T.Setenv("TESTLOOKUP_CACHE_SIZE", EnvUnset)
+	if err := c.ReadConfig([]byte(`{"db": {"host": null, "name": "app"}, "log": null}`)); err != nil {
+		T.Fatal(err)
+	}
+	for path, exp := range map[string]State{
+		"db.host":    Null,
+		"db.port":    Present,
+		"db.name":    Present,
+		"db.user":    Missing,
+		"log":        Null,
+		"log.level":  Null,
+		"cache.size": Null,
+	} {
+		if _, got := c.Lookup(path); got != exp {
+			T.Errorf("%s: invalid result: expected=%v got=%v", path, exp, got)
+		}
+	}
+	if v, _ := c.Lookup("db.port"); v.String() != "5432" || v.Kind() != KindNumber {
+		T.Errorf("invalid result: expected=%v got=%v", 5432, v)
+	}
+	if c.Has("db.host") || c.GetString("db.host") != "" {
+		T.Errorf("null value must hide default")
+	}
+	if got := c.Kind("db.host"); got != KindNull {
+		T.Errorf("invalid result: expected=%v got=%v", KindNull, got)
+	}
+	all := c.All()
+	if _, ok := all["log"]; ok {
+		T.Errorf("null value must be removed from merged tree")
+	}
+	if _, ok := all["cache"].(map[string]any)["size"]; ok {
+		T.Errorf("unset env must remove key from merged tree")
+	}
+
+	// nil values clear defaults at runtime, non-nil values restore them
+	c.Set("db.port", nil)
+	if _, state := c.Lookup("db.port"); state != Null {
+		T.Errorf("invalid result: expected=%v got=%v", Null, state)
+	}
+	c.Set("db.port", 1234)
+	if got := c.GetInt("db.port"); got != 1234 {
+		T.Errorf("invalid result: expected=%v got=%v", 1234, got)
+	}
+}
+
+func TestKeyNormalizer(T *testing.T) {
+	for in, exp := range map[string][2]string{
+		"maxConns":   {"max_conns", "maxConns"},
+		"MaxConns":   {"max_conns", "maxConns"},
+		"max_conns":  {"max_conns", "maxConns"},
+		"max-conns":  {"max_conns", "maxConns"},
+		"HTTPServer": {"http_server", "httpServer"},
+		"ipv4Addr":   {"ipv4_addr", "ipv4Addr"},
+		"host":       {"host", "host"},
+	} {
+		if got := toSnakeCase(in); got != exp[0] {
+			T.Errorf("%s: invalid result: expected=%v got=%v", in, exp[0], got)
+		}
+		if got := toCamelCase(in); got != exp[1] {
+			T.Errorf("%s: invalid result: expected=%v got=%v", in, exp[1], got)
+		}
+	}
+
+	c := NewConfig()
+	c.SetEnvPrefix("TESTNORM")
+	c.SetDefault("db.maxIdle", 2)
+	c.SetKeyNormalizer(SnakeCaseKeys)
+	c.SetDefault("db.MaxLifetime", "1m")
+	T.Setenv("TESTNORM_DB_MAX_IDLE", "4")
+	if err := c.ReadConfig([]byte(`{"DB": {"maxConns": 10, "Labels": {"TeamName": "core"}}}`)); err != nil {
+		T.Fatal(err)
+	}
+	c.Set("db.Host", "localhost")
+	for path, exp := range map[string]string{
+		"db.max_conns":    "10",
+		"db.maxConns":     "10",
+		"Db.MaxConns":     "10",
+		"db.max_idle":     "4",
+		"db.maxIdle":      "4",
+		"db.max_lifetime": "1m",
+		"db.host":         "localhost",
+	} {
+		if got := c.GetString(path); got != exp {
+			T.Errorf("%s: invalid result: expected=%v got=%v", path, exp, got)
+		}
+	}
+	if got := c.GetStringMap("db.labels"); got["team_name"] != "core" {
+		T.Errorf("invalid result: expected=%v got=%v", "core", got)
+	}
+	b, err := c.Branch("DB")
+	if err != nil {
+		T.Fatal(err)
+	}
+	if got := b.GetInt("maxConns"); got != 10 {
+		T.Errorf("invalid result: expected=%v got=%v", 10, got)
+	}
+
+	var db struct {
+		MaxConns int
+		Lifetime string `json:"maxLifetime"`
+		Host     string
+	}
+	if err := c.Unmarshal("db", &db); err != nil {
+		T.Fatal(err)
+	}
+	if db.MaxConns != 10 || db.Lifetime != "1m" || db.Host != "localhost" {
+		T.Errorf("invalid result: got=%+v", db)
+	}
+
+	// stored data keeps keys as written
+	c.Set("db.max_conns", 20)
+	dbData := c.data["DB"].(map[string]any)
+	if exp, got := 20, dbData["maxConns"]; exp != got {
+		T.Errorf("invalid result: expected=%v got=%v", exp, got)
+	}
+	if _, ok := dbData["max_conns"]; ok {
+		T.Errorf("unexpected normalized key in data: %v", dbData)
+	}
+	if labels := dbData["Labels"].(map[string]any); labels["TeamName"] != "core" {
+		T.Errorf("invalid result: got=%v", labels)
+	}
+
+	// control env variables are not normalized
+	c = NewConfig()
+	c.SetEnvPrefix("TESTCTRL")
+	c.SetKeyNormalizer(CamelCaseKeys)
+	name := filepath.Join(T.TempDir(), "app.json")
+	if err := os.WriteFile(name, []byte(`{}`), 0o644); err != nil {
+		T.Fatal(err)
+	}
+	T.Setenv("TESTCTRL_CONFIG_FILE", name)
+	if got := c.ConfigName(); got != name {
+		T.Errorf("invalid config name: expected=%v got=%v", name, got)
+	}
+	if all := c.All(); len(all) != 0 {
+		T.Errorf("control variable merged into data: %v", all)
+	}
+
+	// no data
+	c = NewConfig()
+	c.Use(nil)
+	c.SetKeyNormalizer(CaseInsensitiveKeys)
+	if c.Has("db.host") {
+		T.Errorf("unexpected value")
+	}
+}
+
+func TestSub(T *testing.T) {
+	c := NewConfig()
+	c.SetEnvPrefix("TESTSUB")
+	c.SetDefault("db.port", 5432)
+	T.Setenv("TESTSUB_DB_USER", "admin")
+	if err := c.ReadConfig([]byte(`{"db": {"host": "a", "opts": {"ssl": true}}, "servers": [{"host": "x"}, {"host": "y"}]}`)); err != nil {
+		T.Fatal(err)
+	}
+	db := c.Sub("db")
+	for path, exp := range map[string]string{
+		"host":     "a",
+		"port":     "5432",
+		"user":     "admin",
+		"opts.ssl": "true",
+	} {
+		if got := db.GetString(path); got != exp {
+			T.Errorf("%s: invalid result: expected=%v got=%v", path, exp, got)
+		}
+	}
+	if got := db.Sub("opts").GetBool("ssl"); !got {
+		T.Errorf("invalid result: expected=%v got=%v", true, got)
+	}
+
+	// writes go to the parent, parent changes and reloads are visible
+	db.Set("host", "b")
+	if got := c.GetString("db.host"); got != "b" {
+		T.Errorf("invalid result: expected=%v got=%v", "b", got)
+	}
+	db.SetDefault("name", "app")
+	if got := c.GetString("db.name"); got != "app" {
+		T.Errorf("invalid result: expected=%v got=%v", "app", got)
+	}
+	if err := c.ReadConfig([]byte(`{"db": {"host": "c"}}`)); err != nil {
+		T.Fatal(err)
+	}
+	if got := db.GetString("host"); got != "c" {
+		T.Errorf("invalid result: expected=%v got=%v", "c", got)
+	}
+	if got := db.All()["name"]; got != "app" {
+		T.Errorf("invalid result: expected=%v got=%v", "app", got)
+	}
+
+	// views of a missing path see keys that are added later
+	cache := c.Sub("cache")
+	if cache.Has("size") {
+		T.Errorf("unexpected value")
+	}
+	c.Set("cache.size", 10)
+	if got := cache.GetInt("size"); got != 10 {
+		T.Errorf("invalid result: expected=%v got=%v", 10, got)
+	}
+
+	// ForEach elements are views too
+	c = NewConfig()
+	if err := c.ReadConfig([]byte(`{"servers": [{"host": "x"}, {"host": "y"}]}`)); err != nil {
+		T.Fatal(err)
+	}
+	err := c.ForEach("servers", func(s *Config) error {
+		s.Set("port", 80)
+		return nil
+	})
+	if err != nil {
+		T.Fatal(err)
+	}
+	if got := c.GetInt("servers[1].port"); got != 80 {
+		T.Errorf("invalid result: expected=%v got=%v", 80, got)
+	}
+
+	// registries and env names of views are those of the parent
+	c = NewConfig()
+	c.SetEnvPrefix("TESTSUB")
+	c.Define("svc.port", Spec{Type: TypeInt, Max: 100, Description: "service port"})
+	c.Define("svc.pool", Spec{Type: TypeInt, Deprecated: "use svc.conns"})
+	c.Define("svc.labels", Spec{Type: TypeMap})
+	c.Define("other.port", Spec{Type: TypeInt, Max: 100})
+	T.Setenv("TESTSUB_SVC_LABELS_TEAM", "core")
+	T.Setenv("TESTSUB_SVC_PROT", "1")
+	if err := c.ReadConfig([]byte(`{"svc": {"port": 200, "pool": 1, "labels": {"app": "x"}}, "other": {"port": 300}}`)); err != nil {
+		T.Fatal(err)
+	}
+	svc := c.Sub("svc")
+	if exp, got := map[string]string{"app": "x", "team": "core"}, svc.GetStringMap("labels"); !reflect.DeepEqual(exp, got) {
+		T.Errorf("invalid result: expected=%v got=%v", exp, got)
+	}
+	if help := svc.Help(); !strings.Contains(help, "svc.port <int>") || !strings.Contains(help, "env: TESTSUB_SVC_PORT") || strings.Contains(help, "other.port") {
+		T.Errorf("invalid help text:\n%s", help)
+	}
+	if err := svc.Validate(); err == nil || !strings.Contains(err.Error(), `"svc.port"`) || strings.Contains(err.Error(), "other.port") {
+		T.Errorf("invalid validation result: %v", err)
+	}
+	if msgs := svc.Deprecations(); len(msgs) != 1 || !strings.Contains(msgs[0], "svc.pool") {
+		T.Errorf("invalid deprecations: %v", msgs)
+	}
+	if keys := svc.UnknownKeys(); len(keys) != 1 || keys[0].Key != "TESTSUB_SVC_PROT" {
+		T.Errorf("invalid unknown keys: %v", keys)
+	}
+	if args := svc.Args(); !reflect.DeepEqual(args, []string{"-svc.labels.app=x", "-svc.labels.team=core", "-svc.pool=1", "-svc.port=200", "-svc.prot=1"}) {
+		T.Errorf("invalid args: %v", args)
+	}
+
+	// data and settings changed on views go to the parent
+	if err := svc.ReadConfig([]byte(`{"port": 1}`)); err == nil {
+		T.Errorf("expected error reading config into view")
+	}
+	svc.Use(map[string]any{"port": 2, "token": "${env:TESTSUB_TOKEN}"})
+	if got := c.GetInt("svc.port"); got != 2 {
+		T.Errorf("invalid result: expected=%v got=%v", 2, got)
+	}
+	T.Setenv("TESTSUB_TOKEN", "t")
+	svc.SetInterpolate(true)
+	if got := c.GetString("svc.token"); got != "t" {
+		T.Errorf("invalid result: expected=%v got=%v", "t", got)
+	}
+	key, _ := NewEncryptionKey()
+	if err := svc.SetEncryptionKey([]byte(key)); err != nil {
+		T.Fatal(err)
+	}
+	if err := svc.Encrypt("port"); err != nil {
+		T.Fatal(err)
+	}
+	if raw := c.getRawValue("svc.port"); !strings.HasPrefix(toString(raw), EncPrefix) || svc.GetInt("port") != 2 {
+		T.Errorf("invalid encrypted value: %v", raw)
+	}
+}
+
+func TestForEachKey(T *testing.T) {
+	c := NewConfig()
+	if err := c.ReadConfig([]byte(`{
+		"backends": {"b": {"url": "http://b", "weight": 2}, "a": {"url": "http://a", "weight": 1}},
+		"servers": [{"host": "x", "port": 80}, {"host": "y", "port": 81}],
+		"tags": ["one", "two"],
+		"mixed": [{"host": "x"}, "y"]
+	}`)); err != nil {
+		T.Fatal(err)
+	}
+	names := make([]string, 0)
+	err := c.ForEachKey("backends", func(name string, b *Config) error {
+		names = append(names, name+"="+b.GetString("url"))
+		return nil
+	})
+	if err != nil {
+		T.Fatal(err)
+	}
+	if exp := []string{"a=http://a", "b=http://b"}; !reflect.DeepEqual(exp, names) {
+		T.Errorf("invalid result: expected=%v got=%v", exp, names)
+	}
+	if err := c.ForEachKey("servers", func(string, *Config) error { return nil }); err == nil {
+		T.Errorf("expected error for slice")
+	}
+
+	// the empty path iterates top-level keys
+	root := NewConfig()
+	if err := root.ReadConfig([]byte(`{"db": {"port": 1}, "cache": {"port": 2}}`)); err != nil {
+		T.Fatal(err)
+	}
+	names = names[:0]
+	err = root.ForEachKey("", func(name string, b *Config) error {
+		names = append(names, name+"="+b.GetString("port"))
+		return nil
+	})
+	if exp := []string{"cache=2", "db=1"}; err != nil || !reflect.DeepEqual(exp, names) {
+		T.Errorf("invalid result: expected=%v got=%v %v", exp, names, err)
+	}
+	if got := root.Sub("").GetInt("db.port"); got != 1 {
+		T.Errorf("invalid root view result: expected=%v got=%v", 1, got)
+	}
+
+	type server struct {
+		Host string
+		Port int
+	}
+	servers := make([]server, 0)
+	err = ForEachInto(c, "servers", func(i int, s server) error {
+		servers = append(servers, s)
+		return nil
+	})
+	if err != nil {
+		T.Fatal(err)
+	}
+	if exp := []server{{"x", 80}, {"y", 81}}; !reflect.DeepEqual(exp, servers) {
+		T.Errorf("invalid result: expected=%v got=%v", exp, servers)
+	}
+	tags := make([]string, 0)
+	err = ForEachInto(c, "tags", func(i int, s string) error {
+		tags = append(tags, strconv.Itoa(i)+":"+s)
+		return nil
+	})
+	if err != nil {
+		T.Fatal(err)
+	}
+	if exp := []string{"0:one", "1:two"}; !reflect.DeepEqual(exp, tags) {
+		T.Errorf("invalid result: expected=%v got=%v", exp, tags)
+	}
+	if err := ForEachInto(c, "tags", func(int, server) error { return nil }); err == nil {
+		T.Errorf("expected error for scalar into struct")
+	}
+
+	// scalar elements are errors, not panics
+	if err := c.ForEach("tags", func(*Config) error { return nil }); err == nil {
+		T.Errorf("expected error for scalar elements")
+	}
+	num := 0
+	err = c.ForEach("mixed", func(*Config) error { num++; return nil })
+	if err == nil || num != 1 {
+		T.Errorf("expected error after first element, got num=%d err=%v", num, err)
+	}
+}
+
+func TestWriteConfigFile(T *testing.T) {
+	dir := T.TempDir()
+	name := filepath.Join(dir, "config.json")
+	if err := os.WriteFile(name, []byte(`{"db": {"host": "a"}}`), 0o600); err != nil {
+		T.Fatal(err)
+	}
+	T.Setenv("TESTWRITE_DB_USER", "admin")
+	c := NewConfig()
+	c.SetEnvPrefix("TESTWRITE")
+	c.SetDefault("db.port", 5432)
+	c.SetConfigName(name)
+	if err := c.ReadConfigFile(); err != nil {
+		T.Fatal(err)
+	}
+	c.Set("db.host", "b")
+	c.SetWriteBackup(true)
+	if err := c.WriteConfigFile(""); err != nil {
+		T.Fatal(err)
+	}
+	buf, err := os.ReadFile(name)
+	if err != nil {
+		T.Fatal(err)
+	}
+	if exp, got := "{\n  \"db\": {\n    \"host\": \"b\"\n  }\n}\n", string(buf); exp != got {
+		T.Errorf("invalid result: expected=%q got=%q", exp, got)
+	}
+	if fi, err := os.Stat(name); err != nil || fi.Mode().Perm() != 0o600 {
+		T.Errorf("permissions not preserved: %v %v", fi.Mode(), err)
+	}
+	if buf, err := os.ReadFile(name + ".bak"); err != nil || string(buf) != `{"db": {"host": "a"}}` {
+		T.Errorf("invalid backup: %q %v", buf, err)
+	}
+	files, _ := os.ReadDir(dir)
+	if len(files) != 2 {
+		T.Errorf("unexpected files left: %v", files)
+	}
+	if err := c.SafeWriteConfigFile(name); !errors.Is(err, os.ErrExist) {
+		T.Errorf("expected exists error, got %v", err)
+	}
+	if files, _ := os.ReadDir(dir); len(files) != 2 {
+		T.Errorf("unexpected files left: %v", files)
+	}
+	if err := c.SafeWriteConfigFile(filepath.Join(dir, "new.json")); err != nil {
+		T.Error(err)
+	}
+	if buf, err := os.ReadFile(filepath.Join(dir, "new.json")); err != nil || !strings.Contains(string(buf), `"host"`) {
+		T.Errorf("invalid result: %q %v", buf, err)
+	}
+	if files, _ := os.ReadDir(dir); len(files) != 3 {
+		T.Errorf("unexpected files left: %v", files)
+	}
+
+	// comments in existing JSONC files are never dropped
+	jsonc := filepath.Join(dir, "config.jsonc")
+	if err := c.SafeWriteConfigFile(jsonc); err != nil {
+		T.Error(err)
+	}
+	if err := os.WriteFile(jsonc, []byte("// keep\n{}\n"), 0o600); err != nil {
+		T.Fatal(err)
+	}
+	if err := c.WriteConfigFile(jsonc); err == nil {
+		T.Error("expected error rewriting JSONC file")
+	}
+	if buf, _ := os.ReadFile(jsonc); string(buf) != "// keep\n{}\n" {
+		T.Errorf("JSONC file changed: %q", buf)
+	}
+}
+
+func TestPatch(T *testing.T) {
+	const doc = `{
+    // database settings
+    "db": {
+        "port": 5432,
+        "host": "localhost" /* primary */
+    },
+    "tags": ["a", "b"],
+    "empty": {},
+    "log": {"level": "info"}
+}
+`
+	for _, v := range []struct {
+		op  PatchOp
+		exp string
+	}{
+		{PatchSet("db.host", "db1"), strings.Replace(doc, `"localhost"`, `"db1"`, 1)},
+		{PatchSet("db.port", 6543), strings.Replace(doc, `5432`, `6543`, 1)},
+		{PatchSet("db.user", "admin"), strings.Replace(doc, `"localhost" /* primary */`, "\"localhost\", /* primary */\n        \"user\": \"admin\"", 1)},
+		{PatchSet("tags[2]", "c"), strings.Replace(doc, `"b"]`, `"b", "c"]`, 1)},
+		{PatchSet("tags.0", "x"), strings.Replace(doc, `["a"`, `["x"`, 1)},
+		{PatchSet("log.format", "json"), strings.Replace(doc, `"info"}`, `"info", "format": "json"}`, 1)},
+		{PatchSet("empty.a.b", 1), strings.Replace(doc, `"empty": {}`, "\"empty\": {\n        \"a\": {\n            \"b\": 1\n        }\n    }", 1)},
+		{PatchRemove("db.port"), strings.Replace(doc, "\"port\": 5432,\n        ", "", 1)},
+		{PatchRemove("db.host"), strings.Replace(doc, ",\n        \"host\": \"localhost\" /* primary */", "", 1)},
+		{PatchRemove("tags[1]"), strings.Replace(doc, `["a", "b"]`, `["a"]`, 1)},
+		{PatchRemove("log.level"), strings.Replace(doc, `{"level": "info"}`, `{}`, 1)},
+		{PatchRemove("missing.key"), doc},
+	} {
+		buf, err := PatchJSON([]byte(doc), v.op)
+		if err != nil {
+			T.Errorf("%s %s: %v", v.op.Op, v.op.Path, err)
+			continue
+		}
+		if string(buf) != v.exp {
+			T.Errorf("%s %s: invalid result:\n%s", v.op.Op, v.op.Path, buf)
+		}
+	}
+	for in, exp := range map[string]string{
+		"{\n  \"a\": 1 // one\n}": "{\n  \"a\": 1, // one\n  \"b\": 2\n}",
+		"{\n  \"a\": 1,\n}":       "{\n  \"a\": 1,\n  \"b\": 2\n}",
+		`{"a":1,"c":3}`:           `{"a":1,"c":3,"b":2}`,
+	} {
+		buf, err := PatchJSON([]byte(in), PatchSet("b", 2))
+		if err != nil || string(buf) != exp {
+			T.Errorf("invalid result: expected=%q got=%q %v", exp, buf, err)
+		}
+	}
+	if _, err := PatchJSON([]byte(doc), PatchSet("db.port.x", 1)); err == nil {
+		T.Errorf("expected error for key in scalar")
+	}
+	if _, err := PatchJSON([]byte(doc), PatchSet("tags[5]", 1)); err == nil {
+		T.Errorf("expected error for out of range index")
+	}
+	if _, err := PatchJSON([]byte(doc), PatchSet("list[0].a", 1)); err == nil {
+		T.Errorf("expected error for missing array")
+	}
+	if _, err := PatchJSON([]byte(`{"a": 1} garbage`), PatchSet("a", 2)); err == nil {
+		T.Errorf("expected error for trailing data")
+	}
+	var pe *ParseError
+	if _, err := PatchJSON([]byte(`{"a": 1, "b": tru}`), PatchSet("a", 2)); !errors.As(err, &pe) || pe.Line != 1 || pe.Column != 15 {
+		T.Errorf("expected ParseError for invalid value, got %v", err)
+	}
+	if _, err := PatchJSON([]byte(doc), PatchSet("db.port.x", 1)); err == nil || errors.As(err, &pe) || !strings.HasPrefix(err.Error(), `patch set "db.port.x": `) {
+		T.Errorf("expected plain error for key in scalar, got %v", err)
+	}
+	if buf, err := PatchJSON([]byte(`{a: 'x', b: 0x10,}`), PatchSet("a", "y")); err != nil || string(buf) != `{a: "y", b: 0x10,}` {
+		T.Errorf("invalid JSON5 result: %q %v", buf, err)
+	}
+
+	// comments above a removed member go with it
+	for in, exp := range map[string]string{
+		"{\n  // port of db\n  \"port\": 1,\n  // host of db\n  \"host\": \"a\"\n}":    "{\n  // host of db\n  \"host\": \"a\"\n}",
+		"{\n  \"a\": 1, // one\n  // port of db\n  \"port\": 1,\n  \"host\": \"a\"\n}": "{\n  \"a\": 1, // one\n  \"host\": \"a\"\n}",
+		"{\n  \"a\": 1, // one\n  // port of db\n  \"port\": 1 // last\n}":             "{\n  \"a\": 1 // one\n}",
+		"{ // settings\n  \"port\": 1\n}":                                              "{ // settings\n}",
+	} {
+		buf, err := PatchJSON([]byte(in), PatchRemove("port"))
+		if err != nil || string(buf) != exp {
+			T.Errorf("invalid result: expected=%q got=%q %v", exp, buf, err)
+		}
+	}
+
+	name := filepath.Join(T.TempDir(), "config.json")
+	if err := os.WriteFile(name, []byte(`{"db": {"host": "a", "port": 1}}`), 0o600); err != nil {
+		T.Fatal(err)
+	}
+	c := NewConfig()
+	c.SetConfigName(name)
+	if err := c.ReadConfigFile(); err != nil {
+		T.Fatal(err)
+	}
+	if err := c.Patch("", PatchSet("db.host", "b"), PatchRemove("db.port")); err != nil {
+		T.Fatal(err)
+	}
+	buf, _ := os.ReadFile(name)
+	if exp, got := `{"db": {"host": "b"}}`, string(buf); exp != got {
+		T.Errorf("invalid result: expected=%v got=%v", exp, got)
+	}
+	if c.GetString("db.host") != "b" || c.Has("db.port") {
+		T.Errorf("config data not updated: %v", c.All())
+	}
+
+	// views do not change the caller's ops
+	ops := []PatchOp{PatchSet("host", "c")}
+	if err := c.Sub("db").Patch("", ops...); err != nil {
+		T.Fatal(err)
+	}
+	if ops[0].Path != "host" || c.GetString("db.host") != "c" {
+		T.Errorf("invalid result: path=%v host=%v", ops[0].Path, c.GetString("db.host"))
+	}
+
+	// the patched config must pass schema checks
+	if err := c.SetSchema([]byte(`{"properties": {"db": {"properties": {"host": {"type": "string"}}}}}`)); err != nil {
+		T.Fatal(err)
+	}
+	if err := c.Patch("", PatchSet("db.host", 1)); err == nil {
+		T.Errorf("expected schema error")
+	}
+	if buf, _ := os.ReadFile(name); !strings.Contains(string(buf), `"c"`) || c.GetString("db.host") != "c" {
+		T.Errorf("file or data changed by failed patch: %s", buf)
+	}
+
+	// broken files are reported with their location
+	if err := os.WriteFile(name, []byte("{\n  \"db\": {\"host\": \"c\"},\n  'port': 1\n}\n"), 0o600); err != nil {
+		T.Fatal(err)
+	}
+	if err := c.Patch("", PatchSet("db.host", "d")); !errors.As(err, &pe) || pe.File != name || pe.Line != 3 {
+		T.Errorf("expected ParseError for %s, got %v", name, err)
+	}
+}
+
+func TestJSON5(T *testing.T) {
+	const jsonc = `{
+  // database
+  "db": {
+    "host": "localhost", /* primary */
+    "port": 5432,
+  },
+  "tags": ["a", "b",],
+}`
+	const json5 = `{
+  // database
+  db: {
+    host: 'local\'host',
+    port: 0x1538,
+    ratio: .5,
+    max: +10,
+  },
+  tags: ['a', "b",],
+}`
+	c := NewConfig()
+	if err := c.ReadConfig([]byte(jsonc)); err == nil {
+		T.Errorf("expected error for comments in JSON")
+	}
+	c.SetConfigFormat(FormatJSONC)
+	if err := c.ReadConfig([]byte(jsonc)); err != nil {
+		T.Fatal(err)
+	}
+	if c.GetString("db.host") != "localhost" || c.GetInt("db.port") != 5432 || len(c.GetStringSlice("tags")) != 2 {
+		T.Errorf("invalid result: got=%v", c.All())
+	}
+	if err := c.ReadConfig([]byte(json5)); err == nil {
+		T.Errorf("expected error for JSON5 syntax in JSONC")
+	}
+
+	dir := T.TempDir()
+	name := filepath.Join(dir, "config.json5")
+	if err := os.WriteFile(name, []byte(json5), 0o644); err != nil {
+		T.Fatal(err)
+	}
+	c = NewConfig()
+	c.SetConfigName(name)
+	if err := c.ReadConfigFile(); err != nil {
+		T.Fatal(err)
+	}
+	for path, exp := range map[string]string{
+		"db.host":  "local'host",
+		"db.port":  "5432",
+		"db.ratio": "0.5",
+		"db.max":   "10",
+		"tags.1":   "b",
+	} {
+		if got := c.GetString(path); got != exp {
+			T.Errorf("%s: invalid result: expected=%v got=%v", path, exp, got)
+		}
+	}
+
+	for _, v := range []struct {
+		format, doc, exp string
+	}{
+		{FormatJSON, "{\n  \"a\": 1,\n}", "line 3, column 1"},
+		{FormatJSON, "{\n  \"a\": 1\n  \"b\": 2\n}", "line 3, column 3"},
+		{FormatJSON, "{\"a\": 1", "line 1, column 8"},
+		{FormatJSONC, "{\n  // ok\n  \"a\": x\n}", "line 3, column 8"},
+		{FormatJSON5, "{\n  a: 1,\n  b: Infinity\n}", "line 3, column 6"},
+		{FormatJSON5, "{\n  a: 'x\n}", "line 2, column 6"},
+	} {
+		c := NewConfig()
+		c.SetConfigFormat(v.format)
+		err := c.ReadConfig([]byte(v.doc))
+		if err == nil || !strings.Contains(err.Error(), v.exp) {
+			T.Errorf("%s %q: expected error at %s, got %v", v.format, v.doc, v.exp, err)
+		}
+	}
+}
+
+func TestParseError(T *testing.T) {
+	dir := T.TempDir()
+	name := filepath.Join(dir, "config.json")
+	if err := os.WriteFile(name, []byte("{\n\t\"db\": {\n\t\t\"port\": 5432,\n\t\t\"host\": localhost\n\t}\n}"), 0o644); err != nil {
+		T.Fatal(err)
+	}
+	c := NewConfig()
+	c.SetConfigName(name)
+	err := c.ReadConfigFile()
+	var pe *ParseError
+	if !errors.As(err, &pe) {
+		T.Fatalf("expected ParseError, got %T %v", err, err)
+	}
+	if pe.File != name || pe.Line != 4 || pe.Column != 11 || pe.Cause == nil {
+		T.Errorf("invalid result: got=%+v", pe)
+	}
+	if exp := "\t\t\"host\": localhost\n\t\t        ^"; pe.Snippet != exp {
+		T.Errorf("invalid snippet: expected=%q got=%q", exp, pe.Snippet)
+	}
+	if msg := pe.Error(); !strings.HasPrefix(msg, "parsing config file "+name+": line 4, column 11: ") {
+		T.Errorf("invalid message: %q", msg)
+	}
+	c.SetConfigFormat(FormatJSON5)
+	if err := c.ReadConfigFile(); !errors.As(err, &pe) || pe.Line != 4 {
+		T.Errorf("expected ParseError for JSON5, got %v", err)
+	}
+
+	// type errors found later reference the defining file and line
+	doc := "{\n  \"db\": {\n    \"host\": \"a\",\n    \"port\": \"http\"\n  },\n  \"servers\": [\n    {\"weight\": 1},\n    {\"weight\": true}\n  ]\n}"
+	if err := os.WriteFile(name, []byte(doc), 0o644); err != nil {
+		T.Fatal(err)
+	}
+	c = NewConfig()
+	c.SetConfigName(name)
+	c.Define("db.port", Spec{Type: TypeInt})
+	if err := c.ReadConfigFile(); err != nil {
+		T.Fatal(err)
+	}
+	if err := c.Validate(); err == nil || !strings.Contains(err.Error(), name+":4:13") {
+		T.Errorf("expected location in validation error, got %v", err)
+	}
+	var db struct {
+		Host string
+		Port int
+	}
+	if err := c.Unmarshal("db", &db); err == nil || !strings.Contains(err.Error(), name+":4:13") {
+		T.Errorf("expected location in binding error, got %v", err)
+	}
+	type server struct {
+		Weight int
+	}
+	err = ForEachInto(c, "servers", func(int, server) error { return nil })
+	if err == nil || !strings.Contains(err.Error(), name+":8:16") {
+		T.Errorf("expected location in binding error, got %v", err)
+	}
+	var root struct {
+		DB struct {
+			Port int
+		}
+	}
+	if err := c.Unmarshal("", &root); err == nil || !strings.Contains(err.Error(), name+":4:13") {
+		T.Errorf("expected location in root binding error, got %v", err)
+	}
+	var list struct {
+		Servers []server
+	}
+	if err := c.Unmarshal("", &list); err == nil || !strings.Contains(err.Error(), name+":8:16") {
+		T.Errorf("expected location of slice element, got %v", err)
+	}
+	c.Set("db.port", "x")
+	if err := c.Validate(); err == nil || strings.Contains(err.Error(), "defined at") {
+		T.Errorf("unexpected location for value changed by Set: %v", err)
+	}
+
+	c = NewConfig()
+	c.SetSchema([]byte(`{"properties": {"db": {"properties": {"port": {"type": "integer"}}}}}`))
+	err = c.ReadConfig([]byte(doc))
+	if err == nil || !strings.Contains(err.Error(), "(defined at line 4, column 13)") {
+		T.Errorf("expected location in schema error, got %v", err)
+	}
+}
@@ -0,0 +1,215 @@
+// Copyright (c) 2018-2024 KIDTSUNAMI
+// Author: alex@kidtsunami.com
+
+package config
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// EncPrefix marks encrypted config values. The remainder is the base64
+// encoded AES-256-GCM nonce followed by ciphertext and tag.
+const EncPrefix = "enc:v1:"
+
+const encKeySize = 32
+
+var ErrNoEncryptionKey = errors.New("no encryption key")
+
+func SetEncryptionKey(key []byte) error {
+	return config.SetEncryptionKey(key)
+}
+
+func LoadEncryptionKey(name string) error {
+	return config.LoadEncryptionKey(name)
+}
+
+func EncryptValue(path, val string) (string, error) {
+	return config.EncryptValue(path, val)
+}
+
+func Encrypt(path string) error {
+	return config.Encrypt(path)
+}
+
+// NewEncryptionKey generates a random key in the base64 form accepted by
+// SetEncryptionKey, LoadEncryptionKey and the CONFIG_KEY env variable.
+func NewEncryptionKey() (string, error) {
+	key := make([]byte, encKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(key), nil
+}
+
+// parseKey accepts 32 raw bytes or their hex or base64 encoding.
+func parseKey(buf []byte) ([]byte, error) {
+	s := strings.TrimSpace(string(buf))
+	if len(buf) == encKeySize {
+		return buf, nil
+	}
+	if len(s) == 2*encKeySize {
+		if key, err := hex.DecodeString(s); err == nil {
+			return key, nil
+		}
+	}
+	for _, enc := range []*base64.Encoding{base64.StdEncoding, base64.URLEncoding, base64.RawStdEncoding, base64.RawURLEncoding} {
+		if key, err := enc.DecodeString(s); err == nil && len(key) == encKeySize {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("invalid encryption key: expected %d bytes raw, hex or base64", encKeySize)
+}
+
+func newCipher(key []byte) (cipher.AEAD, error) {
+	k, err := parseKey(key)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(k)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// SetEncryptionKey sets the AES-256 key used for encrypted values.
+func (c *Config) SetEncryptionKey(key []byte) error {
+	if c.parent != nil {
+		return c.parent.SetEncryptionKey(key)
+	}
+	aead, err := newCipher(key)
+	if err != nil {
+		return err
+	}
+	c.secretMu.Lock()
+	c.aead = aead
+	c.secrets = nil
+	c.secretMu.Unlock()
+	return nil
+}
+
+// LoadEncryptionKey reads the encryption key from a file.
+func (c *Config) LoadEncryptionKey(name string) error {
+	buf, err := os.ReadFile(name)
+	if err != nil {
+		return fmt.Errorf("reading key file: %v", err)
+	}
+	return c.SetEncryptionKey(buf)
+}
+
+// encCipher returns the configured AEAD. When no key was set explicitly it
+// is loaded from env variable CONFIG_KEY or the file named in
+// CONFIG_KEY_FILE, both with env prefix.
+func (c *Config) encCipher() (cipher.AEAD, error) {
+	c.secretMu.Lock()
+	defer c.secretMu.Unlock()
+	if c.aead != nil {
+		return c.aead, nil
+	}
+	var key []byte
+	if s, ok := os.LookupEnv(c.controlEnv("CONFIG_KEY")); ok {
+		key = []byte(s)
+	} else if name, ok := os.LookupEnv(c.controlEnv("CONFIG_KEY_FILE")); ok {
+		buf, err := os.ReadFile(name)
+		if err != nil {
+			return nil, fmt.Errorf("reading key file: %v", err)
+		}
+		key = buf
+	} else {
+		return nil, ErrNoEncryptionKey
+	}
+	aead, err := newCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	c.aead = aead
+	return aead, nil
+}
+
+// encData returns the additional data that binds a ciphertext to the
+// normalized key path it is stored at.
+func (c *Config) encData(path string) []byte {
+	return []byte(normPath(c.key(path)))
+}
+
+// EncryptValue encrypts val for the key at path and returns it in the form
+// enc:v1:... that is decrypted transparently by getters. The path is
+// authenticated, so the value cannot be moved to another key.
+func (c *Config) EncryptValue(path, val string) (string, error) {
+	if c.parent != nil {
+		return c.parent.EncryptValue(c.fullPath(path), val)
+	}
+	aead, err := c.encCipher()
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(val)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	buf := aead.Seal(nonce, nonce, []byte(val), c.encData(path))
+	return EncPrefix + base64.StdEncoding.EncodeToString(buf), nil
+}
+
+// Encrypt replaces the plaintext value at path in config data with its
+// encrypted form. Values that are already encrypted are left unchanged.
+func (c *Config) Encrypt(path string) error {
+	if c.parent != nil {
+		return c.parent.Encrypt(c.fullPath(path))
+	}
+	val := c.getIn(c.data, path)
+	if val == nil {
+		return fmt.Errorf("missing config path %q", path)
+	}
+	s := toString(val)
+	if strings.HasPrefix(s, EncPrefix) {
+		return nil
+	}
+	enc, err := c.EncryptValue(path, s)
+	if err != nil {
+		return err
+	}
+	c.Set(path, enc)
+	return nil
+}
+
+// decrypt decodes a value in the form enc:v1:... stored at path and
+// caches the result.
+func (c *Config) decrypt(path, s string) (string, error) {
+	ad := c.encData(path)
+	key := string(ad) + "=" + s
+	c.secretMu.Lock()
+	val, ok := c.secrets[key]
+	c.secretMu.Unlock()
+	if ok {
+		return val, nil
+	}
+	aead, err := c.encCipher()
+	if err != nil {
+		return "", fmt.Errorf("decrypting value: %w", err)
+	}
+	buf, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(s, EncPrefix))
+	if err != nil || len(buf) < aead.NonceSize() {
+		return "", fmt.Errorf("decrypting value: invalid ciphertext")
+	}
+	nonce, data := buf[:aead.NonceSize()], buf[aead.NonceSize():]
+	plain, err := aead.Open(nil, nonce, data, ad)
+	if err != nil {
+		return "", fmt.Errorf("decrypting value: %v", err)
+	}
+	c.secretMu.Lock()
+	if c.secrets == nil {
+		c.secrets = make(map[string]string)
+	}
+	c.secrets[key] = string(plain)
+	c.secretMu.Unlock()
+	return string(plain), nil
+}
@@ -0,0 +1,62 @@
+// Copyright (c) 2018-2024 KIDTSUNAMI
+// Author: alex@kidtsunami.com
+
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+func GetEnum(path string, allowed ...string) (string, error) {
+	return config.GetEnum(path, allowed...)
+}
+
+func SetEnum(path string, allowed []string, def string) *Config {
+	return config.SetEnum(path, allowed, def)
+}
+
+// SetEnum registers the set of allowed values for path and an optional
+// default. Registered sets are used by GetEnum, Validate and Help.
+func (c *Config) SetEnum(path string, allowed []string, def string) *Config {
+	if c.parent != nil {
+		c.parent.SetEnum(c.fullPath(path), allowed, def)
+		return c
+	}
+	if c.enums == nil {
+		c.enums = make(map[string][]string)
+	}
+	c.enums[normPath(c.key(path))] = allowed
+	if def != "" {
+		c.SetDefault(path, def)
+	}
+	return c
+}
+
+// GetEnum returns the value at path in the canonical spelling of the
+// matching allowed value. Matching is case-insensitive. When no allowed
+// values are passed the set registered with SetEnum is used. Missing
+// values return an empty string without error.
+func (c *Config) GetEnum(path string, allowed ...string) (string, error) {
+	if len(allowed) == 0 && c.parent != nil {
+		return c.parent.GetEnum(c.fullPath(path))
+	}
+	if len(allowed) == 0 {
+		allowed = c.enums[normPath(c.key(path))]
+	}
+	val := c.getValue(path)
+	if val == nil {
+		return "", nil
+	}
+	return matchEnum(path, toString(val), allowed)
+}
+
+func matchEnum(path, val string, allowed []string) (string, error) {
+	for _, v := range allowed {
+		if strings.EqualFold(v, val) {
+			return v, nil
+		}
+	}
+	return "", fmt.Errorf("invalid value %q at config path %q, expected one of %s",
+		val, path, strings.Join(allowed, ", "))
+}
@@ -0,0 +1,110 @@
+// Copyright (c) 2018-2024 KIDTSUNAMI
+// Author: alex@kidtsunami.com
+
+package config
+
+import (
+	"strings"
+)
+
+// EnvKeyMapper translates between config keys and env variable names.
+// Names are handled without env prefix.
+type EnvKeyMapper interface {
+	// ToEnv maps a dotted config key to an env variable name.
+	ToEnv(key string) string
+
+	// FromEnv maps an env variable name back to a dotted config key.
+	// Known contains all registered keys and may be used to resolve
+	// ambiguous names.
+	FromEnv(name string, known []string) string
+}
+
+// DefaultEnvMapper uppercases keys and replaces '.' with '_'. Since '_'
+// is also common inside keys, names map back ambiguously: DB_MAX_CONNS
+// becomes db.max.conns.
+type DefaultEnvMapper struct{}
+
+func (DefaultEnvMapper) ToEnv(key string) string {
+	return strings.ToUpper(strings.Replace(key, ".", "_", -1))
+}
+
+func (DefaultEnvMapper) FromEnv(name string, _ []string) string {
+	return strings.ToLower(strings.Replace(name, "_", ".", -1))
+}
+
+// NestedEnvMapper uses a double underscore for nesting and keeps single
+// underscores, so db.max_conns maps to DB__MAX_CONNS and back.
+type NestedEnvMapper struct{}
+
+func (NestedEnvMapper) ToEnv(key string) string {
+	return strings.ToUpper(strings.Replace(key, ".", "__", -1))
+}
+
+func (NestedEnvMapper) FromEnv(name string, _ []string) string {
+	return strings.ToLower(strings.Replace(name, "__", ".", -1))
+}
+
+// RegisteredEnvMapper maps env names back to registered keys when the
+// name matches a registered key or one of its children. Other names and
+// all forward mappings are handled by Base, which defaults to
+// DefaultEnvMapper. This is the default mapper of a Config.
+type RegisteredEnvMapper struct {
+	Base EnvKeyMapper
+}
+
+func (m RegisteredEnvMapper) base() EnvKeyMapper {
+	if m.Base == nil {
+		return DefaultEnvMapper{}
+	}
+	return m.Base
+}
+
+func (m RegisteredEnvMapper) ToEnv(key string) string {
+	return m.base().ToEnv(key)
+}
+
+func (m RegisteredEnvMapper) FromEnv(name string, known []string) string {
+	base := m.base()
+	best, bestLen := "", 0
+	for _, k := range known {
+		n := base.ToEnv(k)
+		if len(n) <= bestLen {
+			continue
+		}
+		switch {
+		case n == name:
+			best, bestLen = k, len(n)
+		case strings.HasPrefix(name, n+"_"):
+			// child of a registered map or slice key
+			rest := strings.TrimLeft(name[len(n):], "_")
+			best, bestLen = k+"."+base.FromEnv(rest, nil), len(n)
+		}
+	}
+	if best != "" {
+		return best
+	}
+	return base.FromEnv(name, known)
+}
+
+func SetEnvKeyMapper(m EnvKeyMapper) *Config {
+	return config.SetEnvKeyMapper(m)
+}
+
+// SetEnvKeyMapper replaces the mapping between config keys and env
+// variable names. Use nil to restore the default.
+func (c *Config) SetEnvKeyMapper(m EnvKeyMapper) *Config {
+	if c.parent != nil {
+		c.parent.SetEnvKeyMapper(m)
+		return c
+	}
+	c.envMapper = m
+	c.merged = nil
+	return c
+}
+
+func (c *Config) envKeyMapper() EnvKeyMapper {
+	if c.envMapper == nil {
+		return RegisteredEnvMapper{}
+	}
+	return c.envMapper
+}
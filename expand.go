@@ -0,0 +1,188 @@
+// Copyright (c) 2018-2024 KIDTSUNAMI
+// Author: alex@kidtsunami.com
+
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Expand resolves env/config variables embedded in a string. Unresolved
+// references are left in place. See ExpandE for the supported syntax.
+func (c *Config) Expand(s string) string {
+	res, _ := c.expand(s, nil)
+	return res
+}
+
+// ExpandE resolves variables embedded in s and reports unresolved
+// references as error. Supported forms are
+//
+//	${name}          value of name, unresolved when missing
+//	${name:-word}    word when name is missing or empty
+//	${name:?message} error with message when name is missing or empty
+//	${name:+word}    word when name is set and not empty, else empty
+//	$${...}          literal ${...}
+//
+// Names without namespace are lowercased and '_' is mapped to '.' before
+// they are looked up in env (with prefix) and config, so ${DB_HOST}
+// resolves db.host. Use ${env:NAME} to read env variable NAME verbatim
+// and ${cfg:db.host} to read a config key verbatim. Other namespaces
+// like ${file:/run/secrets/x} are resolved by the registered
+// SecretResolver when enabled with SetResolveSecrets. Words may contain nested references.
+func (c *Config) ExpandE(s string) (string, error) {
+	return c.expand(s, nil)
+}
+
+// expand resolves references in s. Stack holds the config paths under
+// resolution for cycle detection.
+func (c *Config) expand(s string, stack []string) (string, error) {
+	if !strings.Contains(s, "${") {
+		return s, nil
+	}
+	var (
+		b    strings.Builder
+		errs []error
+	)
+	for len(s) > 0 {
+		i := strings.IndexByte(s, '$')
+		if i < 0 {
+			b.WriteString(s)
+			break
+		}
+		b.WriteString(s[:i])
+		s = s[i:]
+		switch {
+		case strings.HasPrefix(s, "$${"):
+			// escaped reference, keep literal up to matching brace
+			end := matchBrace(s[2:])
+			if end < 0 {
+				b.WriteString(s[1:])
+				s = ""
+				continue
+			}
+			b.WriteString(s[1 : 2+end+1])
+			s = s[2+end+1:]
+		case strings.HasPrefix(s, "${"):
+			end := matchBrace(s[1:])
+			if end < 0 {
+				// unterminated reference
+				b.WriteString(s)
+				s = ""
+				continue
+			}
+			ref := s[:1+end+1]
+			val, err := c.resolveRef(ref[2:len(ref)-1], stack)
+			if err != nil {
+				errs = append(errs, err)
+				b.WriteString(ref)
+			} else {
+				b.WriteString(val)
+			}
+			s = s[len(ref):]
+		default:
+			b.WriteByte('$')
+			s = s[1:]
+		}
+	}
+	return b.String(), errors.Join(errs...)
+}
+
+// matchBrace returns the index of the brace closing the one at s[0].
+func matchBrace(s string) int {
+	depth := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// resolveRef resolves the inner part of a ${...} reference.
+func (c *Config) resolveRef(ref string, stack []string) (string, error) {
+	ns, name := "", ref
+	if n, r, ok := strings.Cut(ref, ":"); ok {
+		if _, isSecret := c.resolver(n); isSecret || n == "cfg" || n == "env" {
+			ns, name = n, r
+		}
+	}
+	op, word := "", ""
+	if i := strings.Index(name, ":"); i >= 0 && i+1 < len(name) {
+		switch name[i+1] {
+		case '-', '?', '+':
+			op, word = name[i:i+2], name[i+2:]
+			name = name[:i]
+		}
+	}
+
+	val, ok, err := c.lookupRef(ns, name, stack)
+	if err != nil {
+		return "", err
+	}
+	switch op {
+	case ":-":
+		if !ok || val == "" {
+			return c.expand(word, stack)
+		}
+	case ":?":
+		if !ok || val == "" {
+			msg, _ := c.expand(word, stack)
+			if msg == "" {
+				msg = "required value is missing"
+			}
+			return "", fmt.Errorf("expanding ${%s}: %s", ref, msg)
+		}
+	case ":+":
+		if ok && val != "" {
+			return c.expand(word, stack)
+		}
+		return "", nil
+	default:
+		if !ok {
+			return "", fmt.Errorf("expanding ${%s}: unresolved reference", ref)
+		}
+	}
+	return val, nil
+}
+
+func (c *Config) lookupRef(ns, name string, stack []string) (string, bool, error) {
+	var path string
+	switch ns {
+	case "":
+		path = strings.ToLower(name)
+		path = strings.Replace(path, "_", ".", -1)
+		// prefer keys spelled verbatim, e.g. ${db.max_conns}
+		if path != name && c.getRawValue(name) != nil {
+			path = name
+		}
+	case "cfg":
+		path = name
+	case "env":
+		// env variables may be missing, which is not an error here
+		if _, ok := c.resolver(ns); !ok {
+			val, ok := os.LookupEnv(name)
+			return val, ok, nil
+		}
+		if _, ok := os.LookupEnv(name); !ok {
+			return "", false, nil
+		}
+		fallthrough
+	default:
+		val, err := c.resolveSecret(ns, name)
+		return val, err == nil, err
+	}
+	val, err := c.resolveValue(path, stack)
+	if err != nil || val == nil {
+		return "", false, err
+	}
+	return toString(val), true, nil
+}
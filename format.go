@@ -0,0 +1,585 @@
+// Copyright (c) 2018-2024 KIDTSUNAMI
+// Author: alex@kidtsunami.com
+
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// Config file formats. JSONC extends JSON with // and /* */ comments and
+// trailing commas. JSON5 adds unquoted keys, single quoted strings,
+// hexadecimal numbers, leading and trailing decimal points and explicit
+// plus signs on top.
+const (
+	FormatJSON  = "json"
+	FormatJSONC = "jsonc"
+	FormatJSON5 = "json5"
+)
+
+func SetConfigFormat(format string) *Config {
+	return config.SetConfigFormat(format)
+}
+
+// SetConfigFormat sets the format used to parse config data. By default
+// ReadConfigFile detects the format from the file extension .json, .jsonc
+// or .json5 and ReadConfig expects JSON. Set FormatJSONC or FormatJSON5
+// to allow comments and other extensions in .json files.
+func (c *Config) SetConfigFormat(format string) *Config {
+	if c.parent != nil {
+		c.parent.SetConfigFormat(format)
+		return c
+	}
+	c.format = strings.ToLower(format)
+	return c
+}
+
+// configFormat returns the format of the named config file.
+func (c *Config) configFormat(name string) string {
+	if c.format != "" {
+		return c.format
+	}
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".jsonc":
+		return FormatJSONC
+	case ".json5":
+		return FormatJSON5
+	}
+	return FormatJSON
+}
+
+// ParseError is returned when a config document cannot be parsed.
+// Line and Column are 1-based, Snippet shows the offending line with a
+// caret below the error position.
+type ParseError struct {
+	File    string
+	Line    int
+	Column  int
+	Snippet string
+	Cause   error
+}
+
+func newParseError(buf []byte, pos int, cause error) *ParseError {
+	line, col := lineCol(buf, pos)
+	return &ParseError{
+		Line:    line,
+		Column:  col,
+		Snippet: snippet(buf, pos),
+		Cause:   cause,
+	}
+}
+
+func (e *ParseError) Error() string {
+	name := ""
+	if e.File != "" {
+		name = " " + e.File
+	}
+	msg := fmt.Sprintf("parsing config file%s: line %d, column %d: %v", name, e.Line, e.Column, e.Cause)
+	if e.Snippet != "" {
+		msg += "\n" + e.Snippet
+	}
+	return msg
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Cause
+}
+
+// snippet returns the line containing pos and a caret marking pos.
+func snippet(buf []byte, pos int) string {
+	if pos > len(buf) {
+		pos = len(buf)
+	}
+	start := bytes.LastIndexByte(buf[:pos], '\n') + 1
+	end := bytes.IndexByte(buf[pos:], '\n')
+	if end < 0 {
+		end = len(buf)
+	} else {
+		end += pos
+	}
+	line := strings.TrimRight(string(buf[start:end]), "\r")
+	// keep tabs so the caret lines up
+	var caret strings.Builder
+	for _, r := range string(buf[start:pos]) {
+		if r == '\t' {
+			caret.WriteByte('\t')
+		} else {
+			caret.WriteByte(' ')
+		}
+	}
+	caret.WriteByte('^')
+	return line + "\n" + caret.String()
+}
+
+// decodeConfig parses a config document in format into a map. Numbers
+// are kept as json.Number. It also returns the offset of every value by
+// path. Syntax errors are returned as *ParseError.
+func decodeConfig(buf []byte, format string) (map[string]any, map[string]int, error) {
+	var (
+		val any
+		err error
+		pos int
+	)
+	offsets := make(map[string]int)
+	switch format {
+	case FormatJSON, "":
+		val, pos, err = decodeStrictJSON(buf)
+		if err == nil {
+			// collect value offsets, JSONC is a superset of JSON
+			p := &json5Parser{buf: buf, offsets: offsets}
+			_, _ = p.document()
+		}
+	case FormatJSONC, FormatJSON5:
+		p := &json5Parser{buf: buf, json5: format == FormatJSON5, offsets: offsets}
+		val, err = p.document()
+		pos = p.pos
+	default:
+		return nil, nil, fmt.Errorf("unsupported config format %q", format)
+	}
+	if err != nil {
+		return nil, nil, newParseError(buf, pos, err)
+	}
+	if val == nil {
+		return nil, offsets, nil
+	}
+	m, ok := val.(map[string]any)
+	if !ok {
+		pos := len(buf) - len(bytes.TrimLeft(buf, " \t\r\n"))
+		return nil, nil, newParseError(buf, pos, fmt.Errorf("expected object at top level, got %T", val))
+	}
+	return m, offsets, nil
+}
+
+// decodeStrictJSON decodes buf and returns the offset of errors.
+func decodeStrictJSON(buf []byte) (any, int, error) {
+	var val any
+	dec := json.NewDecoder(bytes.NewReader(buf))
+	dec.UseNumber()
+	if err := dec.Decode(&val); err != nil {
+		var (
+			se *json.SyntaxError
+			te *json.UnmarshalTypeError
+		)
+		switch {
+		case errors.As(err, &se):
+			// offset points behind the offending byte
+			return nil, int(se.Offset) - 1, err
+		case errors.As(err, &te):
+			return nil, int(te.Offset), err
+		case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+			return nil, len(buf), fmt.Errorf("unexpected end of input")
+		}
+		return nil, 0, err
+	}
+	if dec.More() {
+		pos := int(dec.InputOffset())
+		for pos < len(buf) && isSpace(buf[pos]) {
+			pos++
+		}
+		return nil, pos, fmt.Errorf("invalid data after top-level value")
+	}
+	return val, 0, nil
+}
+
+// lineCol converts a byte offset into 1-based line and column numbers.
+// Columns count characters.
+func lineCol(buf []byte, pos int) (int, int) {
+	if pos > len(buf) {
+		pos = len(buf)
+	}
+	if pos < 0 {
+		pos = 0
+	}
+	line := bytes.Count(buf[:pos], []byte("\n")) + 1
+	start := bytes.LastIndexByte(buf[:pos], '\n') + 1
+	return line, utf8.RuneCount(buf[start:pos]) + 1
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+// json5Parser decodes JSONC and, when json5 is set, JSON5 documents.
+// When offsets is set the offset of every value is stored by path. With
+// spans set the byte ranges of the last value read and its children are
+// left in node, which is what Patch edits.
+type json5Parser struct {
+	buf     []byte
+	pos     int
+	json5   bool
+	offsets map[string]int
+	path    []string
+	spans   bool
+	node    *pnode
+}
+
+// childValue reads the value of an object member or array element.
+func (p *json5Parser) childValue(key string) (any, error) {
+	if p.offsets == nil {
+		return p.value()
+	}
+	p.path = append(p.path, key)
+	p.offsets[formatPath(p.path, nil)] = p.pos
+	val, err := p.value()
+	p.path = p.path[:len(p.path)-1]
+	return val, err
+}
+
+func (p *json5Parser) errorf(format string, args ...any) error {
+	return fmt.Errorf(format, args...)
+}
+
+func (p *json5Parser) document() (any, error) {
+	if err := p.space(); err != nil {
+		return nil, err
+	}
+	val, err := p.value()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.space(); err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.buf) {
+		return nil, p.errorf("invalid data after top-level value")
+	}
+	return val, nil
+}
+
+// space skips white space and comments.
+func (p *json5Parser) space() error {
+	for p.pos < len(p.buf) {
+		c := p.buf[p.pos]
+		switch {
+		case isSpace(c):
+			p.pos++
+		case c == '/' && p.pos+1 < len(p.buf) && p.buf[p.pos+1] == '/':
+			for p.pos < len(p.buf) && p.buf[p.pos] != '\n' {
+				p.pos++
+			}
+		case c == '/' && p.pos+1 < len(p.buf) && p.buf[p.pos+1] == '*':
+			end := bytes.Index(p.buf[p.pos+2:], []byte("*/"))
+			if end < 0 {
+				return p.errorf("unterminated comment")
+			}
+			p.pos += end + 4
+		default:
+			return nil
+		}
+	}
+	return nil
+}
+
+func (p *json5Parser) value() (any, error) {
+	if p.pos >= len(p.buf) {
+		return nil, p.errorf("unexpected end of input")
+	}
+	var (
+		start = p.pos
+		val   any
+		err   error
+	)
+	switch c := p.buf[p.pos]; {
+	case c == '{':
+		return p.object()
+	case c == '[':
+		return p.array()
+	case c == '"' || c == '\'' && p.json5:
+		val, err = p.str()
+	case c == '-' || c == '+' || c == '.' || c >= '0' && c <= '9' || c == 'I' || c == 'N':
+		val, err = p.number()
+	case bytes.HasPrefix(p.buf[p.pos:], []byte("true")):
+		p.pos += 4
+		val = true
+	case bytes.HasPrefix(p.buf[p.pos:], []byte("false")):
+		p.pos += 5
+		val = false
+	case bytes.HasPrefix(p.buf[p.pos:], []byte("null")):
+		p.pos += 4
+	default:
+		r, _ := utf8.DecodeRune(p.buf[p.pos:])
+		return nil, p.errorf("invalid character %q looking for beginning of value", r)
+	}
+	if err == nil && p.spans {
+		p.node = &pnode{start: start, end: p.pos, kind: 's'}
+	}
+	return val, err
+}
+
+func (p *json5Parser) object() (any, error) {
+	var n *pnode
+	if p.spans {
+		n = &pnode{start: p.pos, kind: '{'}
+	}
+	p.pos++ // {
+	m := make(map[string]any)
+	for {
+		if err := p.space(); err != nil {
+			return nil, err
+		}
+		if p.pos < len(p.buf) && p.buf[p.pos] == '}' {
+			p.pos++
+			if n != nil {
+				n.end, p.node = p.pos, n
+			}
+			return m, nil
+		}
+		keyStart := p.pos
+		key, err := p.key()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.space(); err != nil {
+			return nil, err
+		}
+		if p.pos >= len(p.buf) || p.buf[p.pos] != ':' {
+			return nil, p.errorf("expected ':' after object key %q", key)
+		}
+		p.pos++
+		if err := p.space(); err != nil {
+			return nil, err
+		}
+		val, err := p.childValue(key)
+		if err != nil {
+			return nil, err
+		}
+		m[key] = val
+		if n != nil {
+			n.keys = append(n.keys, key)
+			n.keyStart = append(n.keyStart, keyStart)
+			n.elems = append(n.elems, p.node)
+		}
+		if err := p.space(); err != nil {
+			return nil, err
+		}
+		switch {
+		case p.pos < len(p.buf) && p.buf[p.pos] == ',':
+			p.pos++
+		case p.pos < len(p.buf) && p.buf[p.pos] == '}':
+		default:
+			return nil, p.errorf("expected ',' or '}' after object value")
+		}
+	}
+}
+
+func (p *json5Parser) array() (any, error) {
+	var n *pnode
+	if p.spans {
+		n = &pnode{start: p.pos, kind: '['}
+	}
+	p.pos++ // [
+	s := make([]any, 0)
+	for {
+		if err := p.space(); err != nil {
+			return nil, err
+		}
+		if p.pos < len(p.buf) && p.buf[p.pos] == ']' {
+			p.pos++
+			if n != nil {
+				n.end, p.node = p.pos, n
+			}
+			return s, nil
+		}
+		val, err := p.childValue(strconv.Itoa(len(s)))
+		if err != nil {
+			return nil, err
+		}
+		s = append(s, val)
+		if n != nil {
+			n.elems = append(n.elems, p.node)
+		}
+		if err := p.space(); err != nil {
+			return nil, err
+		}
+		switch {
+		case p.pos < len(p.buf) && p.buf[p.pos] == ',':
+			p.pos++
+		case p.pos < len(p.buf) && p.buf[p.pos] == ']':
+		default:
+			return nil, p.errorf("expected ',' or ']' after array element")
+		}
+	}
+}
+
+func (p *json5Parser) key() (string, error) {
+	if p.pos >= len(p.buf) {
+		return "", p.errorf("unexpected end of input")
+	}
+	if c := p.buf[p.pos]; c == '"' || c == '\'' && p.json5 {
+		return p.str()
+	}
+	if !p.json5 {
+		return "", p.errorf("expected quoted object key")
+	}
+	start := p.pos
+	for p.pos < len(p.buf) && isIdentByte(p.buf[p.pos]) {
+		p.pos++
+	}
+	if p.pos == start || p.buf[start] >= '0' && p.buf[start] <= '9' {
+		p.pos = start
+		return "", p.errorf("invalid object key")
+	}
+	return string(p.buf[start:p.pos]), nil
+}
+
+func (p *json5Parser) str() (string, error) {
+	start := p.pos
+	q := p.buf[p.pos]
+	p.pos++
+	var b strings.Builder
+	for p.pos < len(p.buf) {
+		c := p.buf[p.pos]
+		switch {
+		case c == q:
+			p.pos++
+			return b.String(), nil
+		case c == '\n' || c == '\r':
+			p.pos = start
+			return "", p.errorf("unterminated string")
+		case c < 0x20:
+			return "", p.errorf("invalid control character in string")
+		case c == '\\':
+			p.pos++
+			if p.pos >= len(p.buf) {
+				break
+			}
+			e := p.buf[p.pos]
+			p.pos++
+			switch e {
+			case '"', '\\', '/':
+				b.WriteByte(e)
+			case 'b':
+				b.WriteByte('\b')
+			case 'f':
+				b.WriteByte('\f')
+			case 'n':
+				b.WriteByte('\n')
+			case 'r':
+				b.WriteByte('\r')
+			case 't':
+				b.WriteByte('\t')
+			case 'u':
+				r, err := p.hex(4)
+				if err != nil {
+					return "", err
+				}
+				if utf16.IsSurrogate(r) && bytes.HasPrefix(p.buf[p.pos:], []byte(`\u`)) {
+					p.pos += 2
+					r2, err := p.hex(4)
+					if err != nil {
+						return "", err
+					}
+					r = utf16.DecodeRune(r, r2)
+				}
+				b.WriteRune(r)
+			default:
+				if !p.json5 {
+					p.pos -= 2
+					return "", p.errorf("invalid escape sequence")
+				}
+				switch e {
+				case '\'':
+					b.WriteByte(e)
+				case 'v':
+					b.WriteByte('\v')
+				case '0':
+					b.WriteByte(0)
+				case 'x':
+					r, err := p.hex(2)
+					if err != nil {
+						return "", err
+					}
+					b.WriteRune(r)
+				case '\n':
+					// line continuation
+				case '\r':
+					if p.pos < len(p.buf) && p.buf[p.pos] == '\n' {
+						p.pos++
+					}
+				default:
+					b.WriteByte(e)
+				}
+			}
+		default:
+			b.WriteByte(c)
+			p.pos++
+		}
+	}
+	p.pos = start
+	return "", p.errorf("unterminated string")
+}
+
+func isIdentByte(c byte) bool {
+	return c == '_' || c == '$' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c >= 0x80
+}
+
+func (p *json5Parser) hex(n int) (rune, error) {
+	if p.pos+n > len(p.buf) {
+		return 0, p.errorf("invalid escape sequence")
+	}
+	v, err := strconv.ParseUint(string(p.buf[p.pos:p.pos+n]), 16, 32)
+	if err != nil {
+		return 0, p.errorf("invalid escape sequence")
+	}
+	p.pos += n
+	return rune(v), nil
+}
+
+// number reads a number and returns it as json.Number in JSON syntax.
+func (p *json5Parser) number() (any, error) {
+	start := p.pos
+	for p.pos < len(p.buf) && strings.IndexByte("+-.0123456789abcdefABCDEFxXInfinityNa", p.buf[p.pos]) >= 0 {
+		p.pos++
+	}
+	raw := string(p.buf[start:p.pos])
+	text := raw
+	if !p.json5 {
+		if !json.Valid([]byte(text)) || strings.ContainsAny(text, "xXIN") {
+			p.pos = start
+			return nil, p.errorf("invalid number %q", text)
+		}
+		return json.Number(text), nil
+	}
+	sign := ""
+	switch text[0] {
+	case '-':
+		sign, text = "-", text[1:]
+	case '+':
+		text = text[1:]
+	}
+	switch {
+	case text == "Infinity" || text == "NaN":
+		p.pos = start
+		return nil, p.errorf("%s is not supported in config values", text)
+	case strings.HasPrefix(text, "0x") || strings.HasPrefix(text, "0X"):
+		n, ok := new(big.Int).SetString(text[2:], 16)
+		if !ok {
+			break
+		}
+		return json.Number(sign + n.String()), nil
+	default:
+		if strings.HasPrefix(text, ".") {
+			text = "0" + text
+		}
+		if strings.HasSuffix(text, ".") {
+			text += "0"
+		}
+		text = strings.Replace(text, ".e", ".0e", 1)
+		text = strings.Replace(text, ".E", ".0E", 1)
+		if json.Valid([]byte(text)) && !strings.ContainsAny(text, "xXIN") {
+			return json.Number(sign + text), nil
+		}
+	}
+	p.pos = start
+	return nil, p.errorf("invalid number %q", raw)
+}
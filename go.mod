@@ -1,3 +1,3 @@
 module github.com/echa/config
 
-go 1.18
+go 1.21
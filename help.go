@@ -0,0 +1,83 @@
+// Copyright (c) 2018-2024 KIDTSUNAMI
+// Author: alex@kidtsunami.com
+
+package config
+
+import (
+	"strings"
+)
+
+func Help() string {
+	return config.Help()
+}
+
+// Help renders a human-readable list of all registered keys with their
+// types, descriptions, env variable names, defaults and constraints.
+// Views list keys below their path.
+func (c *Config) Help() string {
+	if c.parent != nil {
+		return c.parent.help(c.subPath)
+	}
+	return c.help("")
+}
+
+func (c *Config) help(prefix string) string {
+	var b strings.Builder
+	for _, k := range c.keysBelow(prefix) {
+		spec, ok := c.specs[k]
+		if !ok {
+			spec = &Spec{}
+		}
+		b.WriteString(k)
+		if spec.Type != TypeAny {
+			b.WriteString(" <")
+			b.WriteString(spec.Type.String())
+			b.WriteString(">")
+		}
+		if spec.Required {
+			b.WriteString(" (required)")
+		}
+		b.WriteByte('\n')
+		if spec.Description != "" {
+			b.WriteString("    ")
+			b.WriteString(spec.Description)
+			b.WriteByte('\n')
+		}
+		if !c.noEnv {
+			b.WriteString("    env: ")
+			b.WriteString(c.expandEnvKey(k))
+			b.WriteByte('\n')
+		}
+		if vals, ok := c.enums[k]; ok {
+			b.WriteString("    one of: ")
+			b.WriteString(strings.Join(vals, ", "))
+			b.WriteByte('\n')
+		}
+		if spec.Min != nil {
+			b.WriteString("    min: ")
+			b.WriteString(toString(spec.Min))
+			b.WriteByte('\n')
+		}
+		if spec.Max != nil {
+			b.WriteString("    max: ")
+			b.WriteString(toString(spec.Max))
+			b.WriteByte('\n')
+		}
+		if spec.Pattern != "" {
+			b.WriteString("    pattern: ")
+			b.WriteString(spec.Pattern)
+			b.WriteByte('\n')
+		}
+		if def, ok := c.defaults[k]; ok && !c.IsSecret(k) {
+			b.WriteString("    default: ")
+			b.WriteString(toString(def))
+			b.WriteByte('\n')
+		}
+		if spec.Deprecated != "" {
+			b.WriteString("    deprecated: ")
+			b.WriteString(spec.Deprecated)
+			b.WriteByte('\n')
+		}
+	}
+	return b.String()
+}
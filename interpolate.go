@@ -0,0 +1,214 @@
+// Copyright (c) 2018-2024 KIDTSUNAMI
+// Author: alex@kidtsunami.com
+
+package config
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+func SetInterpolate(enabled bool) *Config {
+	return config.SetInterpolate(enabled)
+}
+
+// SetInterpolate enables resolution of references like ${db.host} inside
+// string values of the merged tree. References use the syntax of ExpandE
+// and are resolved recursively when values are read by getters, All and
+// Unmarshal. Unresolved references are left in place by getters, while
+// All, Unmarshal and Validate report them together with reference cycles.
+func (c *Config) SetInterpolate(enabled bool) *Config {
+	if c.parent != nil {
+		c.parent.SetInterpolate(enabled)
+		return c
+	}
+	c.interpolate = enabled
+	return c
+}
+
+// interpolateValue resolves references in all strings contained in val.
+// Maps and slices are never modified in place, they are copied when they
+// contain resolved values. Stack holds the paths under resolution for
+// cycle detection.
+func (c *Config) interpolateValue(path string, val any, stack []string) (any, error) {
+	res, _, err := c.interpolateNode(path, val, stack)
+	return res, err
+}
+
+// interpolateNode works like interpolateValue and reports whether the
+// result differs from val.
+func (c *Config) interpolateNode(path string, val any, stack []string) (any, bool, error) {
+	switch v := val.(type) {
+	case string:
+		r, err := c.interpolateString(path, v, stack)
+		return r, r != v, err
+	case map[string]any:
+		var (
+			res  map[string]any
+			errs []error
+		)
+		for k, e := range v {
+			r, changed, err := c.interpolateNode(childPath(path, k), e, stack)
+			if err != nil {
+				errs = append(errs, err)
+			}
+			if !changed {
+				continue
+			}
+			if res == nil {
+				res = make(map[string]any, len(v))
+				for k2, e2 := range v {
+					res[k2] = e2
+				}
+			}
+			res[k] = r
+		}
+		if res == nil {
+			return v, false, joinErrors(errs)
+		}
+		return res, true, joinErrors(errs)
+	case []any:
+		var (
+			res  []any
+			errs []error
+		)
+		for i, e := range v {
+			r, changed, err := c.interpolateNode(joinPath(path, strconv.Itoa(i)), e, stack)
+			if err != nil {
+				errs = append(errs, err)
+			}
+			if !changed {
+				continue
+			}
+			if res == nil {
+				res = append([]any(nil), v...)
+			}
+			res[i] = r
+		}
+		if res == nil {
+			return v, false, joinErrors(errs)
+		}
+		return res, true, joinErrors(errs)
+	case []string:
+		var (
+			res  []string
+			errs []error
+		)
+		for i, e := range v {
+			r, err := c.interpolateString(joinPath(path, strconv.Itoa(i)), e, stack)
+			if err != nil {
+				errs = append(errs, err)
+			}
+			if r == e {
+				continue
+			}
+			if res == nil {
+				res = append([]string(nil), v...)
+			}
+			res[i] = r
+		}
+		if res == nil {
+			return v, false, joinErrors(errs)
+		}
+		return res, true, joinErrors(errs)
+	default:
+		return val, false, nil
+	}
+}
+
+func (c *Config) interpolateString(path, s string, stack []string) (string, error) {
+	if strings.HasPrefix(s, EncPrefix) {
+		val, err := c.decrypt(path, s)
+		if err != nil {
+			return s, fmt.Errorf("config path %q: %w", path, err)
+		}
+		return val, nil
+	}
+	if !strings.Contains(s, "${") {
+		return s, nil
+	}
+	if !c.interpolate {
+		// only resolve values that are a single secret reference
+		scheme, ref, ok := c.secretRef(s)
+		if !ok {
+			return s, nil
+		}
+		val, err := c.resolveSecret(scheme, ref)
+		if err != nil {
+			return s, fmt.Errorf("config path %q: %w", path, err)
+		}
+		return val, nil
+	}
+	for i, p := range stack {
+		if p == path {
+			return s, &cycleError{append(append([]string{}, stack[i:]...), path)}
+		}
+	}
+	// never share the backing array with other branches
+	stack = append(stack[:len(stack):len(stack)], path)
+	res, err := c.expand(s, stack)
+	if err != nil {
+		return res, fmt.Errorf("config path %q: %w", path, err)
+	}
+	return res, nil
+}
+
+// cycleError is a reference cycle. The last path repeats the first.
+type cycleError struct {
+	cycle []string
+}
+
+func (e *cycleError) Error() string {
+	return "reference cycle " + strings.Join(e.cycle, " -> ")
+}
+
+// members returns the sorted paths in the cycle, which are the same
+// wherever resolution entered it.
+func (e *cycleError) members() string {
+	m := append([]string{}, e.cycle[:len(e.cycle)-1]...)
+	sort.Strings(m)
+	return strings.Join(m, "\x00")
+}
+
+// joinErrors joins errs like errors.Join and reports each reference
+// cycle once.
+func joinErrors(errs []error) error {
+	var (
+		res  []error
+		seen = make(map[string]bool)
+		add  func(err error)
+	)
+	add = func(err error) {
+		if j, ok := err.(interface{ Unwrap() []error }); ok {
+			for _, e := range j.Unwrap() {
+				add(e)
+			}
+			return
+		}
+		var ce *cycleError
+		if errors.As(err, &ce) {
+			if seen[ce.members()] {
+				return
+			}
+			seen[ce.members()] = true
+		}
+		res = append(res, err)
+	}
+	for _, err := range errs {
+		add(err)
+	}
+	return errors.Join(res...)
+}
+
+// childPath returns the path of member key below path. Keys that contain
+// path syntax are quoted.
+func childPath(path, key string) string {
+	seg := formatPath([]string{key}, nil)
+	if path == "" || strings.HasPrefix(seg, "[") {
+		return path + seg
+	}
+	return path + "." + seg
+}
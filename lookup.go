@@ -0,0 +1,56 @@
+// Copyright (c) 2018-2024 KIDTSUNAMI
+// Author: alex@kidtsunami.com
+
+package config
+
+// State tells whether a config key is set.
+type State byte
+
+const (
+	Missing State = iota // key is not set in any layer
+	Null                 // key is explicitly set to null
+	Present              // key has a value
+)
+
+func (s State) String() string {
+	switch s {
+	case Missing:
+		return "missing"
+	case Null:
+		return "null"
+	case Present:
+		return "present"
+	}
+	return "invalid"
+}
+
+// EnvUnset is the env value that unsets a key, as in APP_DB_HOST=<unset>.
+// Like null in a config file it hides registered defaults. An empty env
+// value sets the key to an empty string instead.
+const EnvUnset = "<unset>"
+
+func stateOf(val any) State {
+	if val == nil {
+		return Null
+	}
+	return Present
+}
+
+func Lookup(path string) (Value, State) {
+	return config.Lookup(path)
+}
+
+// Lookup returns the value at path and whether it is missing, explicitly
+// null or present. Null values from config data (including Set with a nil
+// value) and env variables set to EnvUnset hide registered defaults.
+func (c *Config) Lookup(path string) (Value, State) {
+	if c.parent != nil {
+		return c.parent.Lookup(c.fullPath(path))
+	}
+	val, state := c.lookupRaw(path)
+	if state != Present {
+		return Value{}, state
+	}
+	val, _ = c.interpolateValue(path, val, nil)
+	return Value{val}, state
+}
@@ -0,0 +1,279 @@
+// Copyright (c) 2018-2024 KIDTSUNAMI
+// Author: alex@kidtsunami.com
+
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+func GetIP(path string) (net.IP, error) {
+	return config.GetIP(path)
+}
+
+func GetIPSlice(path string) ([]net.IP, error) {
+	return config.GetIPSlice(path)
+}
+
+func GetIPNet(path string) (*net.IPNet, error) {
+	return config.GetIPNet(path)
+}
+
+func GetIPNetSlice(path string) ([]*net.IPNet, error) {
+	return config.GetIPNetSlice(path)
+}
+
+func GetPrefix(path string) (netip.Prefix, error) {
+	return config.GetPrefix(path)
+}
+
+func GetPrefixSlice(path string) ([]netip.Prefix, error) {
+	return config.GetPrefixSlice(path)
+}
+
+func GetAddrPort(path string) (netip.AddrPort, error) {
+	return config.GetAddrPort(path)
+}
+
+func GetAddrPortSlice(path string) ([]netip.AddrPort, error) {
+	return config.GetAddrPortSlice(path)
+}
+
+func GetHostPort(path string, defaultPort int) (string, error) {
+	return config.GetHostPort(path, defaultPort)
+}
+
+func GetHostPortSlice(path string, defaultPort int) ([]string, error) {
+	return config.GetHostPortSlice(path, defaultPort)
+}
+
+func GetURL(path string) (*url.URL, error) {
+	return config.GetURL(path)
+}
+
+func GetURLSlice(path string) ([]*url.URL, error) {
+	return config.GetURLSlice(path)
+}
+
+// getNetString returns the trimmed string value at path. Missing and
+// empty values are reported as not ok and yield zero results without error.
+func (c *Config) getNetString(path string) (string, bool) {
+	val := c.getValue(path)
+	if val == nil {
+		return "", false
+	}
+	s := strings.TrimSpace(toString(val))
+	return s, s != ""
+}
+
+// getNetSlice applies parse to every non-empty element at path.
+func getNetSlice[T any](c *Config, path string, parse func(string) (T, error)) ([]T, error) {
+	res := make([]T, 0)
+	if !c.Has(path) {
+		return res, nil
+	}
+	for i, v := range c.GetStringSlice(path) {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		t, err := parse(v)
+		if err != nil {
+			return nil, fmt.Errorf("config path %q element %d: %v", path, i, err)
+		}
+		res = append(res, t)
+	}
+	return res, nil
+}
+
+func parseIP(s string) (net.IP, error) {
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid IP address %q", s)
+	}
+	return ip, nil
+}
+
+// parseIPNet parses a CIDR. Bare IP addresses are treated as single host
+// networks.
+func parseIPNet(s string) (*net.IPNet, error) {
+	if !strings.Contains(s, "/") {
+		ip, err := parseIP(s)
+		if err != nil {
+			return nil, err
+		}
+		bits := 128
+		if ip4 := ip.To4(); ip4 != nil {
+			ip, bits = ip4, 32
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+	}
+	_, ipnet, err := net.ParseCIDR(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CIDR %q", s)
+	}
+	return ipnet, nil
+}
+
+// parsePrefix parses a CIDR. Bare IP addresses are treated as single host
+// prefixes.
+func parsePrefix(s string) (netip.Prefix, error) {
+	if !strings.Contains(s, "/") {
+		addr, err := netip.ParseAddr(s)
+		if err != nil {
+			return netip.Prefix{}, fmt.Errorf("invalid IP address %q", s)
+		}
+		return netip.PrefixFrom(addr, addr.BitLen()), nil
+	}
+	pfx, err := netip.ParsePrefix(s)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("invalid CIDR %q", s)
+	}
+	return pfx, nil
+}
+
+func parseAddrPort(s string) (netip.AddrPort, error) {
+	ap, err := netip.ParseAddrPort(s)
+	if err != nil {
+		return netip.AddrPort{}, fmt.Errorf("invalid address %q", s)
+	}
+	return ap, nil
+}
+
+// parseHostPort validates a host:port pair and appends defaultPort when
+// s contains a host only. Hosts may be names, IPv4 or (bracketed) IPv6
+// addresses and may be empty to denote all interfaces.
+func parseHostPort(s string, defaultPort int) (string, error) {
+	host, port, err := net.SplitHostPort(s)
+	if err != nil {
+		// no port or bare IPv6 address
+		host = strings.TrimSuffix(strings.TrimPrefix(s, "["), "]")
+		if strings.ContainsAny(host, "[]") || (strings.Contains(host, ":") && net.ParseIP(host) == nil) {
+			return "", fmt.Errorf("invalid host:port %q", s)
+		}
+		if defaultPort <= 0 {
+			return "", fmt.Errorf("missing port in %q", s)
+		}
+		port = strconv.Itoa(defaultPort)
+	}
+	if p, err := strconv.ParseUint(port, 10, 16); err != nil || p == 0 {
+		return "", fmt.Errorf("invalid port in %q", s)
+	}
+	return net.JoinHostPort(host, port), nil
+}
+
+func parseURL(s string) (*url.URL, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid URL %q: %v", s, err)
+	}
+	if u.Scheme == "" {
+		return nil, fmt.Errorf("invalid URL %q: missing scheme", s)
+	}
+	return u, nil
+}
+
+func (c *Config) GetIP(path string) (net.IP, error) {
+	s, ok := c.getNetString(path)
+	if !ok {
+		return nil, nil
+	}
+	ip, err := parseIP(s)
+	if err != nil {
+		return nil, fmt.Errorf("config path %q: %v", path, err)
+	}
+	return ip, nil
+}
+
+func (c *Config) GetIPSlice(path string) ([]net.IP, error) {
+	return getNetSlice(c, path, parseIP)
+}
+
+func (c *Config) GetIPNet(path string) (*net.IPNet, error) {
+	s, ok := c.getNetString(path)
+	if !ok {
+		return nil, nil
+	}
+	ipnet, err := parseIPNet(s)
+	if err != nil {
+		return nil, fmt.Errorf("config path %q: %v", path, err)
+	}
+	return ipnet, nil
+}
+
+func (c *Config) GetIPNetSlice(path string) ([]*net.IPNet, error) {
+	return getNetSlice(c, path, parseIPNet)
+}
+
+func (c *Config) GetPrefix(path string) (netip.Prefix, error) {
+	s, ok := c.getNetString(path)
+	if !ok {
+		return netip.Prefix{}, nil
+	}
+	pfx, err := parsePrefix(s)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("config path %q: %v", path, err)
+	}
+	return pfx, nil
+}
+
+func (c *Config) GetPrefixSlice(path string) ([]netip.Prefix, error) {
+	return getNetSlice(c, path, parsePrefix)
+}
+
+func (c *Config) GetAddrPort(path string) (netip.AddrPort, error) {
+	s, ok := c.getNetString(path)
+	if !ok {
+		return netip.AddrPort{}, nil
+	}
+	ap, err := parseAddrPort(s)
+	if err != nil {
+		return netip.AddrPort{}, fmt.Errorf("config path %q: %v", path, err)
+	}
+	return ap, nil
+}
+
+func (c *Config) GetAddrPortSlice(path string) ([]netip.AddrPort, error) {
+	return getNetSlice(c, path, parseAddrPort)
+}
+
+// GetHostPort returns a host:port string and adds defaultPort when the
+// configured value has no port. Use defaultPort 0 to require a port.
+func (c *Config) GetHostPort(path string, defaultPort int) (string, error) {
+	s, ok := c.getNetString(path)
+	if !ok {
+		return "", nil
+	}
+	hp, err := parseHostPort(s, defaultPort)
+	if err != nil {
+		return "", fmt.Errorf("config path %q: %v", path, err)
+	}
+	return hp, nil
+}
+
+func (c *Config) GetHostPortSlice(path string, defaultPort int) ([]string, error) {
+	return getNetSlice(c, path, func(s string) (string, error) {
+		return parseHostPort(s, defaultPort)
+	})
+}
+
+func (c *Config) GetURL(path string) (*url.URL, error) {
+	s, ok := c.getNetString(path)
+	if !ok {
+		return nil, nil
+	}
+	u, err := parseURL(s)
+	if err != nil {
+		return nil, fmt.Errorf("config path %q: %v", path, err)
+	}
+	return u, nil
+}
+
+func (c *Config) GetURLSlice(path string) ([]*url.URL, error) {
+	return getNetSlice(c, path, parseURL)
+}
@@ -0,0 +1,267 @@
+// Copyright (c) 2018-2024 KIDTSUNAMI
+// Author: alex@kidtsunami.com
+
+package config
+
+import (
+	"reflect"
+	"strings"
+	"unicode"
+)
+
+// KeyNormalizer maps a single key segment to its canonical spelling so
+// that keys like maxConns, MaxConns and max_conns address the same value.
+type KeyNormalizer interface {
+	NormalizeKey(key string) string
+}
+
+// KeyNormalizerFunc adapts a function to the KeyNormalizer interface.
+type KeyNormalizerFunc func(key string) string
+
+func (f KeyNormalizerFunc) NormalizeKey(key string) string {
+	return f(key)
+}
+
+var (
+	// CaseInsensitiveKeys lowercases keys, so MaxConns matches maxconns.
+	CaseInsensitiveKeys KeyNormalizer = KeyNormalizerFunc(strings.ToLower)
+
+	// SnakeCaseKeys maps maxConns, MaxConns and max-conns to max_conns.
+	SnakeCaseKeys KeyNormalizer = KeyNormalizerFunc(toSnakeCase)
+
+	// CamelCaseKeys maps max_conns, MaxConns and max-conns to maxConns.
+	CamelCaseKeys KeyNormalizer = KeyNormalizerFunc(toCamelCase)
+)
+
+func SetKeyNormalizer(n KeyNormalizer) *Config {
+	return config.SetKeyNormalizer(n)
+}
+
+// SetKeyNormalizer sets how keys are normalized. Paths are converted when
+// they are looked up and compared with normalized keys of config data,
+// so all spellings address the same value. Stored data keeps keys as
+// written, new keys use the spelling of the first Set. Keys of defaults,
+// enums, specs and secret marks are registered in normalized form and env
+// names are derived from normalized keys. Unmarshal matches normalized
+// keys against struct field names. Use nil to keep keys as they are,
+// which is the default.
+func (c *Config) SetKeyNormalizer(n KeyNormalizer) *Config {
+	if c.parent != nil {
+		c.parent.SetKeyNormalizer(n)
+		return c
+	}
+	c.keyNorm = n
+	defaults := make(map[string]any, len(c.defaults))
+	for k, v := range c.defaults {
+		defaults[normPath(c.key(k))] = v
+	}
+	c.defaults = defaults
+	if c.enums != nil {
+		enums := make(map[string][]string, len(c.enums))
+		for k, v := range c.enums {
+			enums[c.key(k)] = v
+		}
+		c.enums = enums
+	}
+	if c.specs != nil {
+		specs := make(map[string]*Spec, len(c.specs))
+		for k, v := range c.specs {
+			specs[c.key(k)] = v
+		}
+		c.specs = specs
+	}
+	if c.secretKeys != nil {
+		secretKeys := make(map[string]bool, len(c.secretKeys))
+		for k, v := range c.secretKeys {
+			secretKeys[c.key(k)] = v
+		}
+		c.secretKeys = secretKeys
+	}
+	c.merged = nil
+	return c
+}
+
+// key returns path with normalized segments. Without normalizer path is
+// returned unchanged.
+func (c *Config) key(path string) string {
+	if c.keyNorm == nil || path == "" {
+		return path
+	}
+	segs := splitPath(path)
+	for i, s := range segs {
+		if s != wildcard {
+			segs[i] = c.keyNorm.NormalizeKey(s)
+		}
+	}
+	return formatPath(segs, nil)
+}
+
+// mapKey normalizes a key of a string map.
+func (c *Config) mapKey(k string) string {
+	if c.keyNorm == nil {
+		return strings.ToLower(k)
+	}
+	return c.keyNorm.NormalizeKey(k)
+}
+
+// getIn returns the value at path in tree, matching normalized keys.
+func (c *Config) getIn(tree any, path string) any {
+	val, _ := lookupKeys(tree, splitPath(path), c.keyNorm)
+	return val
+}
+
+// lookupIn works like getIn and reports whether the value exists.
+func (c *Config) lookupIn(tree any, segs []string) (any, bool) {
+	return lookupKeys(tree, segs, c.keyNorm)
+}
+
+// setIn stores val at path in tree. Existing keys keep their spelling.
+func (c *Config) setIn(tree map[string]any, path string, val any, overwrite bool) error {
+	_, err := setNode(tree, splitPath(path), val, overwrite, c.keyNorm)
+	return err
+}
+
+// deleteIn removes the value at path from tree.
+func (c *Config) deleteIn(tree map[string]any, path string) {
+	deleteKeys(tree, splitPath(path), c.keyNorm)
+}
+
+// splitWords splits s at '_', '-' and spaces and at case changes like
+// in maxConns or HTTPServer.
+func splitWords(s string) []string {
+	words := make([]string, 0)
+	r := []rune(s)
+	start := 0
+	for i := 0; i < len(r); i++ {
+		switch {
+		case r[i] == '_' || r[i] == '-' || r[i] == ' ':
+			if i > start {
+				words = append(words, string(r[start:i]))
+			}
+			start = i + 1
+		case i > start && unicode.IsUpper(r[i]):
+			prev := r[i-1]
+			nextLower := i+1 < len(r) && unicode.IsLower(r[i+1])
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) || (unicode.IsUpper(prev) && nextLower) {
+				words = append(words, string(r[start:i]))
+				start = i
+			}
+		}
+	}
+	if start < len(r) {
+		words = append(words, string(r[start:]))
+	}
+	return words
+}
+
+func toSnakeCase(s string) string {
+	words := splitWords(s)
+	if len(words) == 0 {
+		return strings.ToLower(s)
+	}
+	return strings.ToLower(strings.Join(words, "_"))
+}
+
+func toCamelCase(s string) string {
+	words := splitWords(s)
+	if len(words) == 0 {
+		return strings.ToLower(s)
+	}
+	var b strings.Builder
+	for i, w := range words {
+		w = strings.ToLower(w)
+		if i > 0 {
+			r := []rune(w)
+			r[0] = unicode.ToUpper(r[0])
+			w = string(r)
+		}
+		b.WriteString(w)
+	}
+	return b.String()
+}
+
+// bindKeys renames normalized keys in node to the JSON names of the
+// matching struct fields in t, so that json.Unmarshal finds them.
+func bindKeys(node any, t reflect.Type, n KeyNormalizer) any {
+	if t == nil {
+		return node
+	}
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		m, ok := node.(map[string]any)
+		if !ok {
+			return node
+		}
+		fields := make(map[string]reflect.StructField)
+		structFields(t, n, fields)
+		res := make(map[string]any, len(m))
+		for k, v := range m {
+			if f, ok := fields[n.NormalizeKey(k)]; ok {
+				res[jsonName(f)] = bindKeys(v, f.Type, n)
+			} else {
+				res[k] = v
+			}
+		}
+		return res
+	case reflect.Map:
+		m, ok := node.(map[string]any)
+		if !ok {
+			return node
+		}
+		res := make(map[string]any, len(m))
+		for k, v := range m {
+			res[k] = bindKeys(v, t.Elem(), n)
+		}
+		return res
+	case reflect.Slice, reflect.Array:
+		s, ok := node.([]any)
+		if !ok {
+			return node
+		}
+		res := make([]any, len(s))
+		for i, v := range s {
+			res[i] = bindKeys(v, t.Elem(), n)
+		}
+		return res
+	}
+	return node
+}
+
+// structFields collects exported fields of t by normalized JSON name,
+// including fields of embedded structs.
+func structFields(t reflect.Type, n KeyNormalizer, fields map[string]reflect.StructField) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		if f.Anonymous && tag == "" {
+			ft := f.Type
+			if ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				structFields(ft, n, fields)
+				continue
+			}
+		}
+		if !f.IsExported() {
+			continue
+		}
+		name := n.NormalizeKey(jsonName(f))
+		if _, ok := fields[name]; !ok {
+			fields[name] = f
+		}
+	}
+}
+
+func jsonName(f reflect.StructField) string {
+	if name, _, _ := strings.Cut(f.Tag.Get("json"), ","); name != "" {
+		return name
+	}
+	return f.Name
+}
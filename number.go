@@ -0,0 +1,274 @@
+// Copyright (c) 2018-2024 KIDTSUNAMI
+// Author: alex@kidtsunami.com
+
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+func GetInt8(path string) (int8, error) {
+	return config.GetInt8(path)
+}
+
+func GetInt16(path string) (int16, error) {
+	return config.GetInt16(path)
+}
+
+func GetInt32(path string) (int32, error) {
+	return config.GetInt32(path)
+}
+
+func GetUint8(path string) (uint8, error) {
+	return config.GetUint8(path)
+}
+
+func GetUint16(path string) (uint16, error) {
+	return config.GetUint16(path)
+}
+
+func GetUint32(path string) (uint32, error) {
+	return config.GetUint32(path)
+}
+
+func GetIntE(path string) (int, error) {
+	return config.GetIntE(path)
+}
+
+func GetInt64E(path string) (int64, error) {
+	return config.GetInt64E(path)
+}
+
+func GetUintE(path string) (uint, error) {
+	return config.GetUintE(path)
+}
+
+func GetUint64E(path string) (uint64, error) {
+	return config.GetUint64E(path)
+}
+
+func (c *Config) GetInt8(path string) (int8, error) {
+	i, err := c.getInt(path, 8)
+	return int8(i), err
+}
+
+func (c *Config) GetInt16(path string) (int16, error) {
+	i, err := c.getInt(path, 16)
+	return int16(i), err
+}
+
+func (c *Config) GetInt32(path string) (int32, error) {
+	i, err := c.getInt(path, 32)
+	return int32(i), err
+}
+
+func (c *Config) GetUint8(path string) (uint8, error) {
+	i, err := c.getUint(path, 8)
+	return uint8(i), err
+}
+
+func (c *Config) GetUint16(path string) (uint16, error) {
+	i, err := c.getUint(path, 16)
+	return uint16(i), err
+}
+
+func (c *Config) GetUint32(path string) (uint32, error) {
+	i, err := c.getUint(path, 32)
+	return uint32(i), err
+}
+
+// GetIntE works like GetInt but reports values that are no integers or
+// do not fit as error.
+func (c *Config) GetIntE(path string) (int, error) {
+	i, err := c.getInt(path, strconv.IntSize)
+	return int(i), err
+}
+
+func (c *Config) GetInt64E(path string) (int64, error) {
+	return c.getInt(path, 64)
+}
+
+// GetUintE works like GetUint but reports values that are no unsigned
+// integers or do not fit as error.
+func (c *Config) GetUintE(path string) (uint, error) {
+	i, err := c.getUint(path, strconv.IntSize)
+	return uint(i), err
+}
+
+func (c *Config) GetUint64E(path string) (uint64, error) {
+	return c.getUint(path, 64)
+}
+
+func (c *Config) getInt(path string, bitSize int) (int64, error) {
+	val := c.getValue(path)
+	if val == nil {
+		return 0, nil
+	}
+	i, err := parseInt(val, bitSize)
+	if err != nil {
+		return 0, fmt.Errorf("config path %q: %v", path, err)
+	}
+	return i, nil
+}
+
+func (c *Config) getUint(path string, bitSize int) (uint64, error) {
+	val := c.getValue(path)
+	if val == nil {
+		return 0, nil
+	}
+	i, err := parseUint(val, bitSize)
+	if err != nil {
+		return 0, fmt.Errorf("config path %q: %v", path, err)
+	}
+	return i, nil
+}
+
+var (
+	errNumSyntax = errors.New("invalid syntax")
+	errNumRange  = errors.New("value out of range")
+	errNumZero   = errors.New("ambiguous leading zero, use 0o for octal")
+)
+
+func numError(val any, typ string, bitSize int, err error) error {
+	if errors.Is(err, strconv.ErrRange) {
+		err = errNumRange
+	} else if errors.Is(err, strconv.ErrSyntax) {
+		err = errNumSyntax
+	}
+	return fmt.Errorf("parsing %q as %s%d: %w", toString(val), typ, bitSize, err)
+}
+
+// parseInt converts val into a signed integer of bitSize bits. Strings
+// may use 0x, 0o and 0b base prefixes and _ digit separators. Leading
+// zeros without base prefix like in 0755 are rejected as ambiguous.
+// Floats are accepted when they are integral. Values that do not fit
+// into bitSize are reported as error instead of truncated.
+func parseInt(val any, bitSize int) (int64, error) {
+	lo, hi := int64(-1)<<(bitSize-1), int64(1)<<(bitSize-1)-1
+	var i int64
+	switch v := val.(type) {
+	case int:
+		i = int64(v)
+	case int8:
+		i = int64(v)
+	case int16:
+		i = int64(v)
+	case int32:
+		i = int64(v)
+	case int64:
+		i = v
+	case uint, uint8, uint16, uint32, uint64:
+		u, _ := parseUint(v, 64)
+		if u > uint64(hi) {
+			return 0, numError(val, "int", bitSize, errNumRange)
+		}
+		i = int64(u)
+	case float32:
+		return parseInt(float64(v), bitSize)
+	case float64:
+		if v != math.Trunc(v) {
+			return 0, numError(val, "int", bitSize, errNumSyntax)
+		}
+		if v < float64(lo) || v >= -float64(lo) {
+			return 0, numError(val, "int", bitSize, errNumRange)
+		}
+		i = int64(v)
+	case json.Number:
+		return parseInt(v.String(), bitSize)
+	default:
+		s := strings.TrimSpace(toString(v))
+		if leadingZero(s) {
+			return 0, numError(val, "int", bitSize, errNumZero)
+		}
+		n, err := strconv.ParseInt(s, 0, bitSize)
+		if err == nil {
+			return n, nil
+		}
+		if errors.Is(err, strconv.ErrSyntax) {
+			// try float notation like 1e6
+			if f, ferr := strconv.ParseFloat(s, 64); ferr == nil {
+				return parseInt(f, bitSize)
+			}
+		}
+		return 0, numError(val, "int", bitSize, err)
+	}
+	if i < lo || i > hi {
+		return 0, numError(val, "int", bitSize, errNumRange)
+	}
+	return i, nil
+}
+
+// parseUint converts val into an unsigned integer of bitSize bits using
+// the same syntax rules as parseInt. Negative values are reported as
+// out of range.
+func parseUint(val any, bitSize int) (uint64, error) {
+	hi := uint64(1)<<(bitSize-1)<<1 - 1
+	var u uint64
+	switch v := val.(type) {
+	case uint:
+		u = uint64(v)
+	case uint8:
+		u = uint64(v)
+	case uint16:
+		u = uint64(v)
+	case uint32:
+		u = uint64(v)
+	case uint64:
+		u = v
+	case int, int8, int16, int32, int64:
+		i, _ := parseInt(v, 64)
+		if i < 0 {
+			return 0, numError(val, "uint", bitSize, errNumRange)
+		}
+		u = uint64(i)
+	case float32:
+		return parseUint(float64(v), bitSize)
+	case float64:
+		if v != math.Trunc(v) {
+			return 0, numError(val, "uint", bitSize, errNumSyntax)
+		}
+		if v < 0 || v >= float64(hi)+1 {
+			return 0, numError(val, "uint", bitSize, errNumRange)
+		}
+		u = uint64(v)
+	case json.Number:
+		return parseUint(v.String(), bitSize)
+	default:
+		s := strings.TrimSpace(toString(v))
+		if strings.HasPrefix(s, "-") {
+			return 0, numError(val, "uint", bitSize, errNumRange)
+		}
+		if leadingZero(s) {
+			return 0, numError(val, "uint", bitSize, errNumZero)
+		}
+		n, err := strconv.ParseUint(s, 0, bitSize)
+		if err == nil {
+			return n, nil
+		}
+		if errors.Is(err, strconv.ErrSyntax) {
+			// try float notation like 1e6
+			if f, ferr := strconv.ParseFloat(s, 64); ferr == nil {
+				return parseUint(f, bitSize)
+			}
+		}
+		return 0, numError(val, "uint", bitSize, err)
+	}
+	if u > hi {
+		return 0, numError(val, "uint", bitSize, errNumRange)
+	}
+	return u, nil
+}
+
+// leadingZero reports whether s is an integer with leading zeros and no
+// base prefix, which Go reads as octal and most people as decimal.
+func leadingZero(s string) bool {
+	if len(s) > 0 && (s[0] == '+' || s[0] == '-') {
+		s = s[1:]
+	}
+	return len(s) > 1 && s[0] == '0' && (s[1] >= '0' && s[1] <= '9' || s[1] == '_')
+}
@@ -0,0 +1,423 @@
+// Copyright (c) 2018-2024 KIDTSUNAMI
+// Author: alex@kidtsunami.com
+
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// PatchOp is a single edit applied by Patch. Set operations replace the
+// value at Path or insert it when missing, Remove operations delete it.
+type PatchOp struct {
+	Op    string // "set" or "remove"
+	Path  string
+	Value any
+}
+
+// PatchSet returns an operation that sets path to val.
+func PatchSet(path string, val any) PatchOp {
+	return PatchOp{Op: "set", Path: path, Value: val}
+}
+
+// PatchRemove returns an operation that deletes path.
+func PatchRemove(path string) PatchOp {
+	return PatchOp{Op: "remove", Path: path}
+}
+
+func Patch(name string, ops ...PatchOp) error {
+	return config.Patch(name, ops...)
+}
+
+// Patch applies ops to the named config file, or to ConfigName when name
+// is empty, and to config data. Only the affected values are rewritten,
+// so key order, indentation and comments elsewhere in the file are kept.
+// New keys are appended to their parent object using its indentation.
+// The changed config data is checked against the schema and, in strict
+// mode, for unknown keys before the file is replaced atomically like in
+// WriteConfigFile. A missing file is created. JSON files may contain
+// comments, JSON5 files are read as JSON5. Errors in the file are
+// returned as *ParseError.
+func (c *Config) Patch(name string, ops ...PatchOp) error {
+	if c.parent != nil {
+		full := make([]PatchOp, len(ops))
+		for i, op := range ops {
+			op.Path = c.fullPath(op.Path)
+			full[i] = op
+		}
+		return c.parent.Patch(name, full...)
+	}
+	if name == "" {
+		name = c.ConfigName()
+	}
+	var perm fs.FileMode = 0o644
+	orig, err := os.ReadFile(name)
+	exists := err == nil
+	switch {
+	case exists:
+		if fi, err := os.Stat(name); err == nil {
+			perm = fi.Mode().Perm()
+		}
+	case os.IsNotExist(err):
+		orig = []byte("{}\n")
+	default:
+		return fmt.Errorf("reading config file: %v", err)
+	}
+	buf, err := patchJSON(orig, ops, c.configFormat(name) == FormatJSON5, c.keyNorm)
+	if err != nil {
+		if pe, ok := err.(*ParseError); ok {
+			pe.File = name
+			return pe
+		}
+		return fmt.Errorf("patching config file: %v", err)
+	}
+	data, _ := copyTree(c.data).(map[string]any)
+	if data == nil {
+		data = make(map[string]any)
+	}
+	for _, op := range ops {
+		if op.Op == "remove" {
+			c.deleteIn(data, op.Path)
+		} else {
+			_ = c.setIn(data, op.Path, op.Value, true)
+		}
+	}
+	if err := c.checkData(data, c.sources); err != nil {
+		return err
+	}
+	if c.backup && exists {
+		if err := writeFileAtomic(name+".bak", orig, perm); err != nil {
+			return fmt.Errorf("writing config file backup: %v", err)
+		}
+	}
+	if err := writeFileAtomic(name, buf, perm); err != nil {
+		return fmt.Errorf("writing config file: %v", err)
+	}
+	c.data = data
+	for _, op := range ops {
+		c.forgetSource(op.Path)
+	}
+	c.merged = nil
+	return nil
+}
+
+// PatchJSON applies ops to the JSON document in buf and keeps formatting
+// of all unchanged parts. JSONC and JSON5 syntax is accepted. Syntax
+// errors are reported as *ParseError.
+func PatchJSON(buf []byte, ops ...PatchOp) ([]byte, error) {
+	return patchJSON(buf, ops, true, nil)
+}
+
+func patchJSON(buf []byte, ops []PatchOp, json5 bool, norm KeyNormalizer) ([]byte, error) {
+	for _, op := range ops {
+		segs, err := ParsePath(op.Path)
+		if err != nil {
+			return nil, err
+		}
+		if op.Path == "" || len(segs) == 0 {
+			return nil, fmt.Errorf("empty patch path")
+		}
+		root, err := parseNodes(buf, json5)
+		if err != nil {
+			return nil, err
+		}
+		p := &patcher{buf: buf, norm: norm}
+		switch op.Op {
+		case "set":
+			err = p.set(root, segs, op.Value)
+		case "remove":
+			err = p.remove(root, segs)
+		default:
+			err = fmt.Errorf("unknown patch operation %q", op.Op)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("patch %s %q: %v", op.Op, op.Path, err)
+		}
+		buf = p.buf
+	}
+	return buf, nil
+}
+
+// pnode is a value in a JSON document with its byte range. Containers
+// keep their members or elements.
+type pnode struct {
+	start, end int // value bytes, brackets included
+	kind       byte
+	keys       []string // member keys of objects
+	keyStart   []int    // member key offsets of objects
+	elems      []*pnode // member values or array elements
+}
+
+type patcher struct {
+	buf  []byte
+	norm KeyNormalizer
+}
+
+// parseNodes reads the value spans of a JSONC or, when json5 is set,
+// JSON5 document.
+func parseNodes(buf []byte, json5 bool) (*pnode, error) {
+	p := &json5Parser{buf: buf, json5: json5, spans: true}
+	if _, err := p.document(); err != nil {
+		return nil, newParseError(buf, p.pos, err)
+	}
+	return p.node, nil
+}
+
+// member returns the index of key in object n or -1.
+func (p *patcher) member(n *pnode, key string) int {
+	for i, k := range n.keys {
+		if k == key || p.norm != nil && p.norm.NormalizeKey(k) == p.norm.NormalizeKey(key) {
+			return i
+		}
+	}
+	return -1
+}
+
+func (p *patcher) set(n *pnode, segs []string, val any) error {
+	parent := n
+	for i, seg := range segs {
+		rest := segs[i+1:]
+		switch n.kind {
+		case '{':
+			if k := p.member(n, seg); k >= 0 {
+				parent, n = n, n.elems[k]
+				continue
+			}
+			v, err := nestValue(rest, val)
+			if err != nil {
+				return err
+			}
+			return p.insert(n, strconv.Quote(seg)+": ", v)
+		case '[':
+			idx, err := strconv.Atoi(seg)
+			if err != nil || idx < 0 || idx > len(n.elems) {
+				return fmt.Errorf("invalid array index %q", seg)
+			}
+			if idx < len(n.elems) {
+				parent, n = n, n.elems[idx]
+				continue
+			}
+			v, err := nestValue(rest, val)
+			if err != nil {
+				return err
+			}
+			return p.insert(n, "", v)
+		default:
+			return fmt.Errorf("cannot set key %q in scalar value", seg)
+		}
+	}
+	buf, err := p.marshal(val, p.lineIndent(n.start), p.inline(parent))
+	if err != nil {
+		return err
+	}
+	p.replace(n.start, n.end, buf)
+	return nil
+}
+
+func (p *patcher) remove(n *pnode, segs []string) error {
+	for i, seg := range segs {
+		last := i == len(segs)-1
+		idx := -1
+		switch n.kind {
+		case '{':
+			idx = p.member(n, seg)
+		case '[':
+			if k, err := strconv.Atoi(seg); err == nil && k >= 0 && k < len(n.elems) {
+				idx = k
+			}
+		}
+		if idx < 0 {
+			// nothing to remove
+			return nil
+		}
+		if !last {
+			n = n.elems[idx]
+			continue
+		}
+		// cut from the end of the previous member, including comments on
+		// its line, through the trailing comma of the removed member, so
+		// comments above the removed member go with it
+		var start int
+		if idx == 0 {
+			start = p.trailing(n.start + 1)
+		} else {
+			start = p.trailing(n.elems[idx-1].end)
+			if p.buf[start] == ',' {
+				start = p.trailing(start + 1)
+			}
+		}
+		end := p.trailing(n.elems[idx].end)
+		hasComma := p.buf[end] == ','
+		if hasComma {
+			end = p.trailing(end + 1)
+		}
+		p.replace(start, end, nil)
+		if !hasComma && idx > 0 {
+			// the previous member is now the last one
+			if k := p.trailing(n.elems[idx-1].end); p.buf[k] == ',' {
+				p.replace(k, k+1, nil)
+			}
+		}
+	}
+	return nil
+}
+
+// insert adds a member or element with the given key prefix at the end
+// of container n, following the layout of existing members.
+func (p *patcher) insert(n *pnode, prefix string, val any) error {
+	indent := p.lineIndent(n.start)
+	compact := p.inline(n) && len(n.elems) > 0
+	var sep, closing string
+	switch {
+	case len(n.elems) == 0:
+		sep = "\n" + indent + p.indentUnit()
+		closing = "\n" + indent
+		indent += p.indentUnit()
+	case compact:
+		sep = " "
+		if len(n.elems) > 1 {
+			// copy spacing after the first comma
+			gap := string(p.buf[n.elems[0].end:p.elemStart(n, 1)])
+			if i := strings.IndexByte(gap, ','); i >= 0 && strings.TrimSpace(gap[i+1:]) == "" {
+				sep = gap[i+1:]
+			}
+		}
+	default:
+		gap := string(p.buf[n.start+1 : p.elemStart(n, 0)])
+		if i := strings.LastIndexByte(gap, '\n'); i >= 0 {
+			indent = gap[i+1:]
+		}
+		sep = "\n" + indent
+	}
+	if n.kind == '{' && len(n.elems) > 0 {
+		// copy spacing after the colon
+		head := string(p.buf[n.keyStart[0]:n.elems[0].start])
+		if gap := head[strings.LastIndexByte(head, ':')+1:]; strings.TrimSpace(gap) == "" {
+			prefix = strings.TrimSuffix(prefix, " ") + gap
+		}
+	}
+	buf, err := p.marshal(val, indent, compact)
+	if err != nil {
+		return err
+	}
+	if len(n.elems) == 0 {
+		p.replace(n.start+1, n.end-1, []byte(sep+prefix+string(buf)+closing))
+		return nil
+	}
+	// keep comments that trail the last value on its line
+	pos := n.elems[len(n.elems)-1].end
+	end := p.trailing(pos)
+	if p.buf[end] == ',' {
+		p.replace(end+1, end+1, []byte(sep+prefix+string(buf)))
+		return nil
+	}
+	p.replace(end, end, []byte(sep+prefix+string(buf)))
+	p.replace(pos, pos, []byte(","))
+	return nil
+}
+
+// elemStart returns the offset of member key or element i of n.
+func (p *patcher) elemStart(n *pnode, i int) int {
+	if n.kind == '{' {
+		return n.keyStart[i]
+	}
+	return n.elems[i].start
+}
+
+// trailing returns the offset after comments that follow pos on the same
+// line, or the offset of a trailing comma.
+func (p *patcher) trailing(pos int) int {
+	end := pos
+	for i := pos; i < len(p.buf); {
+		switch c := p.buf[i]; {
+		case c == ' ' || c == '\t':
+			i++
+		case c == ',':
+			return i
+		case c == '/' && i+1 < len(p.buf) && p.buf[i+1] == '/':
+			for i < len(p.buf) && p.buf[i] != '\n' {
+				i++
+			}
+			return i
+		case c == '/' && i+1 < len(p.buf) && p.buf[i+1] == '*':
+			k := bytes.Index(p.buf[i+2:], []byte("*/"))
+			if k < 0 {
+				return end
+			}
+			i += k + 4
+			end = i
+		default:
+			return end
+		}
+	}
+	return end
+}
+
+func (p *patcher) replace(start, end int, b []byte) {
+	buf := make([]byte, 0, len(p.buf)-(end-start)+len(b))
+	buf = append(buf, p.buf[:start]...)
+	buf = append(buf, b...)
+	p.buf = append(buf, p.buf[end:]...)
+}
+
+// lineIndent returns the leading white space of the line containing pos.
+func (p *patcher) lineIndent(pos int) string {
+	start := bytes.LastIndexByte(p.buf[:pos], '\n') + 1
+	end := start
+	for end < len(p.buf) && (p.buf[end] == ' ' || p.buf[end] == '\t') {
+		end++
+	}
+	return string(p.buf[start:end])
+}
+
+// indentUnit returns the indentation of the first indented line.
+func (p *patcher) indentUnit() string {
+	for _, line := range bytes.Split(p.buf, []byte("\n")) {
+		n := 0
+		for n < len(line) && (line[n] == ' ' || line[n] == '\t') {
+			n++
+		}
+		if n > 0 && n < len(line) {
+			return string(line[:n])
+		}
+	}
+	return "  "
+}
+
+// inline reports whether container n is written on a single line.
+func (p *patcher) inline(n *pnode) bool {
+	return bytes.IndexByte(p.buf[n.start:n.end], '\n') < 0
+}
+
+// marshal encodes val, indenting multi-line values relative to indent
+// unless compact is set.
+func (p *patcher) marshal(val any, indent string, compact bool) ([]byte, error) {
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	if !compact {
+		enc.SetIndent(indent, p.indentUnit())
+	}
+	if err := enc.Encode(val); err != nil {
+		return nil, err
+	}
+	return bytes.TrimRight(b.Bytes(), "\n"), nil
+}
+
+// nestValue wraps val into objects for each missing key in segs. Arrays
+// are not created, so numeric segments are rejected.
+func nestValue(segs []string, val any) (any, error) {
+	for i := len(segs) - 1; i >= 0; i-- {
+		if _, err := strconv.Atoi(segs[i]); err == nil {
+			return nil, fmt.Errorf("missing array for index %q", segs[i])
+		}
+		val = map[string]any{segs[i]: val}
+	}
+	return val, nil
+}
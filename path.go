@@ -0,0 +1,213 @@
+// Copyright (c) 2018-2024 KIDTSUNAMI
+// Author: alex@kidtsunami.com
+
+package config
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Paths address values in the config tree. Supported forms are
+//
+//	db.host                      dotted keys
+//	servers.2.host               numeric segments index slices
+//	servers[2].host              bracket indexes
+//	labels["app.kubernetes.io"]  quoted keys that contain dots
+//	/servers/2/host              RFC 6901 JSON Pointer
+//
+// Query patterns may use * or [*] to match any key or index.
+
+const wildcard = "*"
+
+// ParsePath splits a path into its segments. The empty path addresses
+// the root and has no segments.
+func ParsePath(path string) ([]string, error) {
+	if path == "" {
+		return nil, nil
+	}
+	if strings.HasPrefix(path, "/") {
+		return parsePointer(path), nil
+	}
+	segs := make([]string, 0)
+	i := 0
+	for {
+		if i < len(path) && path[i] == '[' {
+			end, key, err := parseBracket(path, i)
+			if err != nil {
+				return nil, err
+			}
+			segs = append(segs, key)
+			i = end + 1
+			if i == len(path) {
+				return segs, nil
+			}
+			switch path[i] {
+			case '[':
+			case '.':
+				i++
+			default:
+				return nil, fmt.Errorf("invalid path %q: unexpected %q after ']'", path, path[i])
+			}
+			continue
+		}
+		j := i
+		for j < len(path) && path[j] != '.' && path[j] != '[' {
+			j++
+		}
+		segs = append(segs, path[i:j])
+		if j == len(path) {
+			return segs, nil
+		}
+		if path[j] == '.' {
+			j++
+		}
+		i = j
+	}
+}
+
+// parseBracket parses a bracket segment starting at path[i] and returns
+// the index of the closing bracket and the unquoted key.
+func parseBracket(path string, i int) (int, string, error) {
+	if i+1 >= len(path) {
+		return 0, "", fmt.Errorf("invalid path %q: unterminated '['", path)
+	}
+	switch q := path[i+1]; q {
+	case '"', '\'':
+		var key strings.Builder
+		for j := i + 2; j < len(path); j++ {
+			switch path[j] {
+			case '\\':
+				if j+1 < len(path) {
+					j++
+					key.WriteByte(path[j])
+				}
+			case q:
+				if j+1 >= len(path) || path[j+1] != ']' {
+					return 0, "", fmt.Errorf("invalid path %q: expected ']' after quoted key", path)
+				}
+				return j + 1, key.String(), nil
+			default:
+				key.WriteByte(path[j])
+			}
+		}
+		return 0, "", fmt.Errorf("invalid path %q: unterminated quoted key", path)
+	default:
+		end := strings.IndexByte(path[i:], ']')
+		if end < 0 {
+			return 0, "", fmt.Errorf("invalid path %q: unterminated '['", path)
+		}
+		key := path[i+1 : i+end]
+		if key != wildcard {
+			if n, err := strconv.Atoi(key); err != nil || n < 0 {
+				return 0, "", fmt.Errorf("invalid path %q: invalid index %q", path, key)
+			}
+		}
+		return i + end, key, nil
+	}
+}
+
+func parsePointer(ptr string) []string {
+	segs := strings.Split(ptr[1:], "/")
+	for i, s := range segs {
+		segs[i] = strings.NewReplacer("~1", "/", "~0", "~").Replace(s)
+	}
+	return segs
+}
+
+// splitPath parses path and falls back to splitting on dots for paths
+// that do not parse.
+func splitPath(path string) []string {
+	segs, err := ParsePath(path)
+	if err != nil {
+		return strings.Split(path, ".")
+	}
+	return segs
+}
+
+// normPath returns the canonical form of path. Plain keys and indexes
+// are joined by dots as in db.servers.0.host, other keys are quoted.
+func normPath(path string) string {
+	return formatPath(splitPath(path), nil)
+}
+
+// formatPath renders segments as path. When node is set, indexes into
+// slices found in node are rendered in bracket form.
+func formatPath(segs []string, node any) string {
+	var b strings.Builder
+	for _, s := range segs {
+		_, isSlice := node.([]any)
+		switch {
+		case isSlice:
+			b.WriteString("[" + s + "]")
+		case s == "" || strings.ContainsAny(s, ".[]\"'") || (b.Len() == 0 && strings.HasPrefix(s, "/")):
+			b.WriteString("[" + strconv.Quote(s) + "]")
+		default:
+			if b.Len() > 0 {
+				b.WriteByte('.')
+			}
+			b.WriteString(s)
+		}
+		if node != nil {
+			node = getNode(node, []string{s})
+		}
+	}
+	return b.String()
+}
+
+// Match is a value found by Query together with its concrete path.
+type Match struct {
+	Path  string
+	Value any
+}
+
+func Query(pattern string) ([]Match, error) {
+	return config.Query(pattern)
+}
+
+// Query returns all values in the merged tree that match pattern, which
+// may contain wildcard segments like servers[*].host. Matches are sorted
+// by path, slice elements in index order.
+func (c *Config) Query(pattern string) ([]Match, error) {
+	segs, err := ParsePath(c.key(pattern))
+	if err != nil {
+		return nil, err
+	}
+	all, err := c.allE()
+	if err != nil {
+		return nil, err
+	}
+	res := make([]Match, 0)
+	var walk func(node any, rest, done []string)
+	walk = func(node any, rest, done []string) {
+		if len(rest) == 0 {
+			res = append(res, Match{Path: formatPath(done, all), Value: node})
+			return
+		}
+		if rest[0] != wildcard {
+			if sub, _ := c.lookupIn(node, rest[:1]); sub != nil {
+				walk(sub, rest[1:], append(done, rest[0]))
+			}
+			return
+		}
+		switch n := node.(type) {
+		case map[string]any:
+			keys := make([]string, 0, len(n))
+			for k := range n {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				walk(n[k], rest[1:], append(done[:len(done):len(done)], k))
+			}
+		case []any:
+			for i, v := range n {
+				walk(v, rest[1:], append(done[:len(done):len(done)], strconv.Itoa(i)))
+			}
+		}
+	}
+	walk(all, segs, nil)
+	return res, nil
+}
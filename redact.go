@@ -0,0 +1,215 @@
+// Copyright (c) 2018-2024 KIDTSUNAMI
+// Author: alex@kidtsunami.com
+
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Redacted replaces secret values in dumps, Args and logs.
+const Redacted = "[REDACTED]"
+
+// DefaultSecretPatterns match keys that are treated as secret unless
+// replaced with SetSecretPatterns. Patterns use path.Match syntax on
+// lowercase dotted keys where '*' also matches dots.
+var DefaultSecretPatterns = []string{
+	"*password*",
+	"*passwd*",
+	"*secret*",
+	"*token*",
+	"*credential*",
+	"*.key",
+	"*_key",
+	"key",
+}
+
+func SetSecret(path string, secret bool) *Config {
+	return config.SetSecret(path, secret)
+}
+
+func SetSecretPatterns(patterns ...string) *Config {
+	return config.SetSecretPatterns(patterns...)
+}
+
+func IsSecret(path string) bool {
+	return config.IsSecret(path)
+}
+
+func Dump() string {
+	return config.Dump()
+}
+
+func Explain(path string) string {
+	return config.Explain(path)
+}
+
+// SetSecret explicitly marks path as secret or not secret. Explicit
+// marks take precedence over specs and patterns.
+func (c *Config) SetSecret(path string, secret bool) *Config {
+	if c.parent != nil {
+		c.parent.SetSecret(c.fullPath(path), secret)
+		return c
+	}
+	if c.secretKeys == nil {
+		c.secretKeys = make(map[string]bool)
+	}
+	c.secretKeys[c.key(path)] = secret
+	return c
+}
+
+// SetSecretPatterns replaces the patterns used to detect secret keys.
+// Call without arguments to disable pattern matching.
+func (c *Config) SetSecretPatterns(patterns ...string) *Config {
+	if c.parent != nil {
+		c.parent.SetSecretPatterns(patterns...)
+		return c
+	}
+	c.secretPatterns = make([]string, len(patterns))
+	for i, p := range patterns {
+		c.secretPatterns[i] = strings.ToLower(p)
+	}
+	return c
+}
+
+// IsSecret reports whether the value at path must be redacted. Values
+// below a secret key are secret as well.
+func (c *Config) IsSecret(key string) bool {
+	if c.parent != nil {
+		return c.parent.IsSecret(c.fullPath(key))
+	}
+	if c.branchName != "" {
+		key = c.branchName + "." + key
+	}
+	key = c.key(key)
+	for k := key; k != ""; {
+		if v, ok := c.secretKeys[k]; ok {
+			return v
+		}
+		if spec, ok := c.specs[k]; ok && spec.Secret {
+			return true
+		}
+		i := strings.LastIndexByte(k, '.')
+		if i < 0 {
+			break
+		}
+		k = k[:i]
+	}
+	patterns := c.secretPatterns
+	if patterns == nil {
+		patterns = DefaultSecretPatterns
+	}
+	key = strings.ToLower(key)
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, key); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// redact returns a copy of val with all secret values replaced.
+func (c *Config) redact(key string, val any) any {
+	if key != "" && c.IsSecret(key) {
+		return Redacted
+	}
+	switch v := val.(type) {
+	case map[string]any:
+		res := make(map[string]any, len(v))
+		for k, e := range v {
+			res[k] = c.redact(joinPath(key, k), e)
+		}
+		return res
+	case []any:
+		res := make([]any, len(v))
+		for i, e := range v {
+			res[i] = c.redact(joinPath(key, strconv.Itoa(i)), e)
+		}
+		return res
+	default:
+		return val
+	}
+}
+
+// String renders the merged config as compact JSON with secret values
+// redacted.
+func (c *Config) String() string {
+	buf, err := json.Marshal(c.redact("", c.All()))
+	if err != nil {
+		return fmt.Sprintf("config: %v", err)
+	}
+	return string(buf)
+}
+
+// Dump renders the merged config as indented JSON with secret values
+// redacted.
+func (c *Config) Dump() string {
+	buf, err := json.MarshalIndent(c.redact("", c.All()), "", "  ")
+	if err != nil {
+		return fmt.Sprintf("config: %v", err)
+	}
+	return string(buf)
+}
+
+// Explain describes the value at path and where it was defined.
+// Secret values are redacted.
+func (c *Config) Explain(key string) string {
+	if c.parent != nil {
+		return c.parent.Explain(c.fullPath(key))
+	}
+	key = c.key(key)
+	if _, state := c.lookupRaw(key); state == Null {
+		return key + " is null"
+	}
+	var src string
+	_, isEnv := c.getEnv(key)
+	_, isDefault := c.defaults[normPath(key)]
+	switch {
+	case isEnv:
+		src = "env " + c.expandEnvKey(key)
+	case c.getIn(c.data, key) != nil:
+		src = "config"
+	case isDefault:
+		src = "default"
+	case c.merged != nil && c.getIn(c.merged, key) != nil:
+		src = "env"
+	default:
+		return key + " is not set"
+	}
+	val := c.getValue(key)
+	s := toString(c.redact(key, val))
+	if _, ok := val.(map[string]any); ok {
+		buf, _ := json.Marshal(c.redact(key, val))
+		s = string(buf)
+	}
+	return fmt.Sprintf("%s = %s (from %s)", key, s, src)
+}
+
+// LogValue implements slog.LogValuer and logs the merged config as
+// nested groups with secret values redacted.
+func (c *Config) LogValue() slog.Value {
+	return logValue(c.redact("", c.All()))
+}
+
+func logValue(val any) slog.Value {
+	m, ok := val.(map[string]any)
+	if !ok {
+		return slog.AnyValue(val)
+	}
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	attrs := make([]slog.Attr, len(keys))
+	for i, k := range keys {
+		attrs[i] = slog.Attr{Key: k, Value: logValue(m[k])}
+	}
+	return slog.GroupValue(attrs...)
+}
@@ -0,0 +1,604 @@
+// Copyright (c) 2018-2024 KIDTSUNAMI
+// Author: alex@kidtsunami.com
+
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+func SetSchema(schema []byte) error {
+	return config.SetSchema(schema)
+}
+
+func ValidateSchema(schema []byte) error {
+	return config.ValidateSchema(schema)
+}
+
+// SchemaError describes a single JSON Schema violation at a dotted
+// config path. Array elements use their index as path segment.
+type SchemaError struct {
+	Path    string
+	Keyword string
+	Message string
+	Source  string // file and line where Path is defined, if known
+}
+
+func (e *SchemaError) Error() string {
+	path := e.Path
+	if path == "" {
+		path = "(root)"
+	}
+	if e.Source != "" {
+		return fmt.Sprintf("config path %q: %s: %s (defined at %s)", path, e.Keyword, e.Message, e.Source)
+	}
+	return fmt.Sprintf("config path %q: %s: %s", path, e.Keyword, e.Message)
+}
+
+// SchemaErrors collects all violations found in a single validation run.
+type SchemaErrors []*SchemaError
+
+func (e SchemaErrors) Error() string {
+	s := make([]string, len(e))
+	for i, v := range e {
+		s[i] = v.Error()
+	}
+	return strings.Join(s, "\n")
+}
+
+func (e SchemaErrors) Unwrap() []error {
+	errs := make([]error, len(e))
+	for i, v := range e {
+		errs[i] = v
+	}
+	return errs
+}
+
+// SetSchema registers a JSON Schema that is checked by Validate and
+// every time a config file is read. A config that fails validation
+// is not loaded. Use a nil schema to disable validation.
+func (c *Config) SetSchema(schema []byte) error {
+	if c.parent != nil {
+		return c.parent.SetSchema(schema)
+	}
+	if schema == nil {
+		c.schema = nil
+		return nil
+	}
+	s, err := parseSchema(schema)
+	if err != nil {
+		return err
+	}
+	c.schema = s
+	return nil
+}
+
+// ValidateSchema validates the merged config tree against a JSON Schema
+// (draft 2020-12). Supported are the type, enum, const, required,
+// properties, patternProperties, additionalProperties, items,
+// prefixItems, min/maxItems, uniqueItems, min/maxProperties,
+// min/maxLength, pattern, minimum, maximum, exclusiveMinimum,
+// exclusiveMaximum, multipleOf, allOf, anyOf, oneOf, not and local $ref
+// keywords. Other keywords are ignored. Strings are accepted for number,
+// integer and boolean types when they parse, because env overrides are
+// always strings.
+func (c *Config) ValidateSchema(schema []byte) error {
+	s, err := parseSchema(schema)
+	if err != nil {
+		return err
+	}
+	return s.validate(c.All())
+}
+
+type jsonSchema struct {
+	root     any
+	patterns map[string]*regexp.Regexp
+}
+
+func parseSchema(buf []byte) (*jsonSchema, error) {
+	var root any
+	if err := decodeJSON(buf, &root); err != nil {
+		return nil, fmt.Errorf("parsing schema: %v", err)
+	}
+	switch root.(type) {
+	case bool, map[string]any:
+	default:
+		return nil, fmt.Errorf("parsing schema: invalid schema type %T", root)
+	}
+	s := &jsonSchema{
+		root:     root,
+		patterns: make(map[string]*regexp.Regexp),
+	}
+	if err := s.compile(root); err != nil {
+		return nil, fmt.Errorf("parsing schema: %v", err)
+	}
+	return s, nil
+}
+
+// compile pre-compiles all regular expressions found in the schema.
+func (s *jsonSchema) compile(node any) error {
+	switch n := node.(type) {
+	case map[string]any:
+		for k, v := range n {
+			switch k {
+			case "pattern":
+				if err := s.addPattern(v); err != nil {
+					return err
+				}
+			case "patternProperties":
+				if m, ok := v.(map[string]any); ok {
+					for p := range m {
+						if err := s.addPattern(p); err != nil {
+							return err
+						}
+					}
+				}
+			case "enum", "const":
+				continue
+			}
+			if err := s.compile(v); err != nil {
+				return err
+			}
+		}
+	case []any:
+		for _, v := range n {
+			if err := s.compile(v); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *jsonSchema) addPattern(v any) error {
+	p, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("invalid pattern %v", v)
+	}
+	re, err := regexp.Compile(p)
+	if err != nil {
+		return fmt.Errorf("invalid pattern %q: %v", p, err)
+	}
+	s.patterns[p] = re
+	return nil
+}
+
+func (s *jsonSchema) validate(tree map[string]any) error {
+	// normalize Go values from defaults and Set into JSON types
+	var doc any
+	buf, err := json.Marshal(tree)
+	if err != nil {
+		return fmt.Errorf("validating config: %v", err)
+	}
+	if err := decodeJSON(buf, &doc); err != nil {
+		return fmt.Errorf("validating config: %v", err)
+	}
+	var errs SchemaErrors
+	s.check(s.root, doc, "", 0, &errs)
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+const maxSchemaDepth = 64
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func (s *jsonSchema) check(node, val any, path string, depth int, errs *SchemaErrors) {
+	fail := func(kw, format string, args ...any) {
+		*errs = append(*errs, &SchemaError{
+			Path:    path,
+			Keyword: kw,
+			Message: fmt.Sprintf(format, args...),
+		})
+	}
+	if depth > maxSchemaDepth {
+		fail("$ref", "schema nesting too deep")
+		return
+	}
+	var schema map[string]any
+	switch n := node.(type) {
+	case bool:
+		if !n {
+			fail("false", "value not allowed")
+		}
+		return
+	case map[string]any:
+		schema = n
+	default:
+		return
+	}
+
+	if ref, ok := schema["$ref"].(string); ok {
+		sub, err := s.resolve(ref)
+		if err != nil {
+			fail("$ref", "%v", err)
+		} else {
+			s.check(sub, val, path, depth+1, errs)
+		}
+	}
+
+	if t, ok := schema["type"]; ok {
+		var types []string
+		switch tv := t.(type) {
+		case string:
+			types = []string{tv}
+		case []any:
+			for _, v := range tv {
+				types = append(types, toString(v))
+			}
+		}
+		matched := false
+		for _, typ := range types {
+			if hasType(val, typ) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			fail("type", "expected %s, got %s", strings.Join(types, " or "), typeName(val))
+			// other keywords would only produce follow-up errors
+			return
+		}
+	}
+
+	if enum, ok := schema["enum"].([]any); ok {
+		found := false
+		for _, e := range enum {
+			if jsonEqual(e, val) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			fail("enum", "value %s is not one of %s", jsonString(val), jsonString(enum))
+		}
+	}
+	if cv, ok := schema["const"]; ok && !jsonEqual(cv, val) {
+		fail("const", "value %s must be %s", jsonString(val), jsonString(cv))
+	}
+
+	if subs, ok := schema["allOf"].([]any); ok {
+		for _, sub := range subs {
+			s.check(sub, val, path, depth+1, errs)
+		}
+	}
+	if subs, ok := schema["anyOf"].([]any); ok {
+		if s.countValid(subs, val, path, depth) == 0 {
+			fail("anyOf", "value does not match any schema")
+		}
+	}
+	if subs, ok := schema["oneOf"].([]any); ok {
+		if n := s.countValid(subs, val, path, depth); n != 1 {
+			fail("oneOf", "value matches %d schemas, expected exactly one", n)
+		}
+	}
+	if sub, ok := schema["not"]; ok {
+		if s.countValid([]any{sub}, val, path, depth) > 0 {
+			fail("not", "value must not match schema")
+		}
+	}
+
+	switch v := val.(type) {
+	case map[string]any:
+		s.checkObject(schema, v, path, depth, errs, fail)
+	case []any:
+		s.checkArray(schema, v, path, depth, errs, fail)
+	case string:
+		s.checkString(schema, v, fail)
+		if f, ok := parseNumber(v); ok {
+			s.checkNumber(schema, f, fail)
+		}
+	case json.Number:
+		if f, ok := parseNumber(v.String()); ok {
+			s.checkNumber(schema, f, fail)
+		}
+	}
+}
+
+func (s *jsonSchema) countValid(subs []any, val any, path string, depth int) int {
+	var n int
+	for _, sub := range subs {
+		var serrs SchemaErrors
+		s.check(sub, val, path, depth+1, &serrs)
+		if len(serrs) == 0 {
+			n++
+		}
+	}
+	return n
+}
+
+func (s *jsonSchema) checkObject(schema, obj map[string]any, path string, depth int, errs *SchemaErrors, fail func(string, string, ...any)) {
+	if req, ok := schema["required"].([]any); ok {
+		for _, r := range req {
+			if _, ok := obj[toString(r)]; !ok {
+				fail("required", "missing required key %q", toString(r))
+			}
+		}
+	}
+	if n, ok := schemaInt(schema, "minProperties"); ok && len(obj) < n {
+		fail("minProperties", "expected at least %d keys, got %d", n, len(obj))
+	}
+	if n, ok := schemaInt(schema, "maxProperties"); ok && len(obj) > n {
+		fail("maxProperties", "expected at most %d keys, got %d", n, len(obj))
+	}
+
+	props, _ := schema["properties"].(map[string]any)
+	patternProps, _ := schema["patternProperties"].(map[string]any)
+	addl, hasAddl := schema["additionalProperties"]
+
+	keys := make([]string, 0, len(obj))
+	for k := range obj {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		v := obj[k]
+		matched := false
+		if sub, ok := props[k]; ok {
+			s.check(sub, v, joinPath(path, k), depth+1, errs)
+			matched = true
+		}
+		for p, sub := range patternProps {
+			if re := s.patterns[p]; re != nil && re.MatchString(k) {
+				s.check(sub, v, joinPath(path, k), depth+1, errs)
+				matched = true
+			}
+		}
+		if matched || !hasAddl {
+			continue
+		}
+		if b, ok := addl.(bool); ok && !b {
+			*errs = append(*errs, &SchemaError{
+				Path:    joinPath(path, k),
+				Keyword: "additionalProperties",
+				Message: "unknown key",
+			})
+			continue
+		}
+		s.check(addl, v, joinPath(path, k), depth+1, errs)
+	}
+}
+
+func (s *jsonSchema) checkArray(schema map[string]any, arr []any, path string, depth int, errs *SchemaErrors, fail func(string, string, ...any)) {
+	if n, ok := schemaInt(schema, "minItems"); ok && len(arr) < n {
+		fail("minItems", "expected at least %d items, got %d", n, len(arr))
+	}
+	if n, ok := schemaInt(schema, "maxItems"); ok && len(arr) > n {
+		fail("maxItems", "expected at most %d items, got %d", n, len(arr))
+	}
+	if u, ok := schema["uniqueItems"].(bool); ok && u {
+		for i := range arr {
+			for j := 0; j < i; j++ {
+				if jsonEqual(arr[i], arr[j]) {
+					fail("uniqueItems", "items %d and %d are equal", j, i)
+				}
+			}
+		}
+	}
+	prefix, _ := schema["prefixItems"].([]any)
+	for i, v := range arr {
+		p := joinPath(path, strconv.Itoa(i))
+		if i < len(prefix) {
+			s.check(prefix[i], v, p, depth+1, errs)
+		} else if items, ok := schema["items"]; ok {
+			s.check(items, v, p, depth+1, errs)
+		}
+	}
+}
+
+func (s *jsonSchema) checkString(schema map[string]any, str string, fail func(string, string, ...any)) {
+	l := len([]rune(str))
+	if n, ok := schemaInt(schema, "minLength"); ok && l < n {
+		fail("minLength", "expected at least %d characters, got %d", n, l)
+	}
+	if n, ok := schemaInt(schema, "maxLength"); ok && l > n {
+		fail("maxLength", "expected at most %d characters, got %d", n, l)
+	}
+	if p, ok := schema["pattern"].(string); ok {
+		if re := s.patterns[p]; re != nil && !re.MatchString(str) {
+			fail("pattern", "value %q does not match pattern %q", str, p)
+		}
+	}
+}
+
+func (s *jsonSchema) checkNumber(schema map[string]any, f *big.Float, fail func(string, string, ...any)) {
+	for _, kw := range []string{"minimum", "maximum", "exclusiveMinimum", "exclusiveMaximum"} {
+		lim, ok := schemaNumber(schema, kw)
+		if !ok {
+			continue
+		}
+		cmp := f.Cmp(lim)
+		switch {
+		case kw == "minimum" && cmp < 0:
+			fail(kw, "value %s is less than %s", f.Text('g', -1), lim.Text('g', -1))
+		case kw == "maximum" && cmp > 0:
+			fail(kw, "value %s exceeds %s", f.Text('g', -1), lim.Text('g', -1))
+		case kw == "exclusiveMinimum" && cmp <= 0:
+			fail(kw, "value %s must be greater than %s", f.Text('g', -1), lim.Text('g', -1))
+		case kw == "exclusiveMaximum" && cmp >= 0:
+			fail(kw, "value %s must be less than %s", f.Text('g', -1), lim.Text('g', -1))
+		}
+	}
+	if m, ok := schemaNumber(schema, "multipleOf"); ok && m.Sign() > 0 {
+		if !new(big.Float).Quo(f, m).IsInt() {
+			fail("multipleOf", "value %s is not a multiple of %s", f.Text('g', -1), m.Text('g', -1))
+		}
+	}
+}
+
+// resolve looks up a local JSON pointer reference like #/$defs/port.
+func (s *jsonSchema) resolve(ref string) (any, error) {
+	if !strings.HasPrefix(ref, "#") {
+		return nil, fmt.Errorf("unsupported reference %q", ref)
+	}
+	node := s.root
+	ptr := strings.TrimPrefix(ref, "#")
+	if ptr == "" {
+		return node, nil
+	}
+	for _, seg := range strings.Split(strings.TrimPrefix(ptr, "/"), "/") {
+		seg = strings.NewReplacer("~1", "/", "~0", "~").Replace(seg)
+		switch n := node.(type) {
+		case map[string]any:
+			sub, ok := n[seg]
+			if !ok {
+				return nil, fmt.Errorf("unresolved reference %q", ref)
+			}
+			node = sub
+		case []any:
+			i, err := strconv.Atoi(seg)
+			if err != nil || i < 0 || i >= len(n) {
+				return nil, fmt.Errorf("unresolved reference %q", ref)
+			}
+			node = n[i]
+		default:
+			return nil, fmt.Errorf("unresolved reference %q", ref)
+		}
+	}
+	return node, nil
+}
+
+func schemaInt(schema map[string]any, kw string) (int, bool) {
+	n, ok := schema[kw].(json.Number)
+	if !ok {
+		return 0, false
+	}
+	i, err := n.Int64()
+	return int(i), err == nil
+}
+
+func schemaNumber(schema map[string]any, kw string) (*big.Float, bool) {
+	n, ok := schema[kw].(json.Number)
+	if !ok {
+		return nil, false
+	}
+	return parseNumber(n.String())
+}
+
+func parseNumber(s string) (*big.Float, bool) {
+	if s == "" {
+		return nil, false
+	}
+	f, ok := parseBigFloat(s)
+	if !ok || f.IsInf() {
+		return nil, false
+	}
+	return f, true
+}
+
+// hasType checks val against a JSON Schema type name. Strings are
+// accepted for scalar types when they parse as such.
+func hasType(val any, typ string) bool {
+	switch typ {
+	case "null":
+		return val == nil
+	case "object":
+		_, ok := val.(map[string]any)
+		return ok
+	case "array":
+		_, ok := val.([]any)
+		return ok
+	case "string":
+		_, ok := val.(string)
+		return ok
+	case "boolean":
+		switch v := val.(type) {
+		case bool:
+			return true
+		case string:
+			_, err := strconv.ParseBool(v)
+			return err == nil
+		}
+	case "number", "integer":
+		var f *big.Float
+		var ok bool
+		switch v := val.(type) {
+		case json.Number:
+			f, ok = parseNumber(v.String())
+		case string:
+			f, ok = parseNumber(v)
+		}
+		return ok && (typ == "number" || f.IsInt())
+	}
+	return false
+}
+
+func typeName(val any) string {
+	switch v := val.(type) {
+	case nil:
+		return "null"
+	case map[string]any:
+		return "object"
+	case []any:
+		return "array"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case json.Number:
+		if f, ok := parseNumber(v.String()); ok && f.IsInt() {
+			return "integer"
+		}
+		return "number"
+	}
+	return fmt.Sprintf("%T", val)
+}
+
+// jsonEqual compares two decoded JSON values. Numbers are compared by
+// value, so 1 and 1.0 are equal.
+func jsonEqual(a, b any) bool {
+	an, aok := a.(json.Number)
+	bn, bok := b.(json.Number)
+	if aok && bok {
+		af, aok := parseNumber(an.String())
+		bf, bok := parseNumber(bn.String())
+		return aok && bok && af.Cmp(bf) == 0
+	}
+	switch av := a.(type) {
+	case map[string]any:
+		bv, ok := b.(map[string]any)
+		if !ok || len(av) != len(bv) {
+			return false
+		}
+		for k, v := range av {
+			if w, ok := bv[k]; !ok || !jsonEqual(v, w) {
+				return false
+			}
+		}
+		return true
+	case []any:
+		bv, ok := b.([]any)
+		if !ok || len(av) != len(bv) {
+			return false
+		}
+		for i := range av {
+			if !jsonEqual(av[i], bv[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+func jsonString(val any) string {
+	buf, err := json.Marshal(val)
+	if err != nil {
+		return fmt.Sprint(val)
+	}
+	return string(buf)
+}
@@ -0,0 +1,183 @@
+// Copyright (c) 2018-2024 KIDTSUNAMI
+// Author: alex@kidtsunami.com
+
+package config
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// SecretResolver resolves secret references of the form ${scheme:ref}
+// found in config values. Resolvers are registered per scheme.
+type SecretResolver interface {
+	Resolve(ref string) (string, error)
+}
+
+// SecretResolverFunc adapts a function to the SecretResolver interface.
+type SecretResolverFunc func(ref string) (string, error)
+
+func (f SecretResolverFunc) Resolve(ref string) (string, error) {
+	return f(ref)
+}
+
+// FileResolver reads secrets from files like Docker and Kubernetes
+// secret mounts. Trailing newlines are removed.
+type FileResolver struct{}
+
+func (FileResolver) Resolve(ref string) (string, error) {
+	buf, err := os.ReadFile(ref)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(buf), "\r\n"), nil
+}
+
+// EnvResolver reads secrets from env variables without prefix.
+type EnvResolver struct{}
+
+func (EnvResolver) Resolve(ref string) (string, error) {
+	val, ok := os.LookupEnv(ref)
+	if !ok {
+		return "", fmt.Errorf("env variable %q not set", ref)
+	}
+	return val, nil
+}
+
+// Base64Resolver decodes standard or URL-safe base64 data, padded or not.
+type Base64Resolver struct{}
+
+func (Base64Resolver) Resolve(ref string) (string, error) {
+	for _, enc := range []*base64.Encoding{
+		base64.StdEncoding,
+		base64.URLEncoding,
+		base64.RawStdEncoding,
+		base64.RawURLEncoding,
+	} {
+		if buf, err := enc.DecodeString(ref); err == nil {
+			return string(buf), nil
+		}
+	}
+	return "", fmt.Errorf("invalid base64 data")
+}
+
+func defaultResolvers() map[string]SecretResolver {
+	return map[string]SecretResolver{
+		"file":   FileResolver{},
+		"env":    EnvResolver{},
+		"base64": Base64Resolver{},
+	}
+}
+
+func SetResolveSecrets(enabled bool) *Config {
+	return config.SetResolveSecrets(enabled)
+}
+
+func RegisterSecretResolver(scheme string, r SecretResolver) *Config {
+	return config.RegisterSecretResolver(scheme, r)
+}
+
+func ResetSecrets() *Config {
+	return config.ResetSecrets()
+}
+
+// SetResolveSecrets enables resolution of secret references like
+// ${file:/run/secrets/db} in config values, env values included. It is
+// disabled by default, so values are taken literally.
+func (c *Config) SetResolveSecrets(enabled bool) *Config {
+	if c.parent != nil {
+		c.parent.SetResolveSecrets(enabled)
+		return c
+	}
+	c.resolveSecrets = enabled
+	c.ResetSecrets()
+	return c
+}
+
+// RegisterSecretResolver registers r for references ${scheme:...}. The
+// file, env and base64 schemes are registered by default and can be
+// replaced. Use a nil resolver to remove a scheme. Resolvers are only
+// used after SetResolveSecrets(true).
+func (c *Config) RegisterSecretResolver(scheme string, r SecretResolver) *Config {
+	if c.parent != nil {
+		c.parent.RegisterSecretResolver(scheme, r)
+		return c
+	}
+	if c.resolvers == nil {
+		c.resolvers = make(map[string]SecretResolver)
+	}
+	if r == nil {
+		delete(c.resolvers, scheme)
+	} else {
+		c.resolvers[scheme] = r
+	}
+	c.ResetSecrets()
+	return c
+}
+
+// ResetSecrets clears cached secrets so that they are resolved again
+// on next read. The cache is also cleared when config data is read.
+func (c *Config) ResetSecrets() *Config {
+	if c.parent != nil {
+		c.parent.ResetSecrets()
+		return c
+	}
+	c.secretMu.Lock()
+	c.secrets = nil
+	c.secretMu.Unlock()
+	return c
+}
+
+// resolver returns the resolver for scheme when secrets are enabled.
+func (c *Config) resolver(scheme string) (SecretResolver, bool) {
+	if !c.resolveSecrets {
+		return nil, false
+	}
+	r, ok := c.resolvers[scheme]
+	return r, ok
+}
+
+// resolveSecret resolves ref with the resolver registered for scheme and
+// caches the result.
+func (c *Config) resolveSecret(scheme, ref string) (string, error) {
+	key := scheme + ":" + ref
+	c.secretMu.Lock()
+	val, ok := c.secrets[key]
+	c.secretMu.Unlock()
+	if ok {
+		return val, nil
+	}
+	r, ok := c.resolver(scheme)
+	if !ok {
+		return "", fmt.Errorf("no secret resolver for scheme %q", scheme)
+	}
+	val, err := r.Resolve(ref)
+	if err != nil {
+		return "", fmt.Errorf("resolving secret ${%s}: %v", key, err)
+	}
+	c.secretMu.Lock()
+	if c.secrets == nil {
+		c.secrets = make(map[string]string)
+	}
+	c.secrets[key] = val
+	c.secretMu.Unlock()
+	return val, nil
+}
+
+// secretRef splits a string consisting of a single ${scheme:ref} with a
+// registered scheme.
+func (c *Config) secretRef(s string) (string, string, bool) {
+	if !strings.HasPrefix(s, "${") || matchBrace(s[1:]) != len(s)-2 {
+		return "", "", false
+	}
+	scheme, ref, ok := strings.Cut(s[2:len(s)-1], ":")
+	if !ok {
+		return "", "", false
+	}
+	if _, ok := c.resolver(scheme); !ok {
+		return "", "", false
+	}
+	return scheme, ref, true
+}
@@ -0,0 +1,158 @@
+// Copyright (c) 2018-2024 KIDTSUNAMI
+// Author: alex@kidtsunami.com
+
+package config
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// keyPos is the location of a key in a config file.
+type keyPos struct {
+	file string
+	line int
+	col  int
+}
+
+func (p keyPos) String() string {
+	if p.file == "" {
+		return fmt.Sprintf("line %d, column %d", p.line, p.col)
+	}
+	return fmt.Sprintf("%s:%d:%d", p.file, p.line, p.col)
+}
+
+// newSources converts value offsets of a parsed document into key
+// locations. Locations of top-level keys that are kept from previously
+// read data are retained.
+func (c *Config) newSources(buf []byte, name string, data map[string]any, offsets map[string]int) map[string]keyPos {
+	sources := make(map[string]keyPos, len(offsets))
+	for k, v := range c.sources {
+		if _, ok := data[splitPath(k)[0]]; ok {
+			sources[k] = v
+		}
+	}
+	for path, off := range offsets {
+		line, col := lineCol(buf, off)
+		sources[normPath(c.key(path))] = keyPos{file: name, line: line, col: col}
+	}
+	return sources
+}
+
+// sourceOf returns where the value at key was read from. Keys set by env
+// have no file location.
+func (c *Config) sourceOf(key string) (keyPos, bool) {
+	if c.parent != nil {
+		return c.parent.sourceOf(c.fullPath(key))
+	}
+	key = normPath(c.key(key))
+	if _, ok := c.getEnv(key); ok {
+		return keyPos{}, false
+	}
+	pos, ok := c.sources[key]
+	return pos, ok
+}
+
+// withSource adds the location of key to err.
+func (c *Config) withSource(key string, err error) error {
+	if pos, ok := c.sourceOf(key); ok {
+		return fmt.Errorf("%w (defined at %s)", err, pos)
+	}
+	return err
+}
+
+// findSource returns the location of a struct field path as reported by
+// json.UnmarshalTypeError below path. Field names match keys case
+// insensitively and slice indexes exactly. Go versions before 1.22 omit
+// indexes from field paths, then any element matches and the first match
+// in the file wins.
+func (c *Config) findSource(path, field string) (keyPos, bool) {
+	base := ""
+	if path != "" {
+		base = normPath(c.key(path))
+	}
+	want := splitPath(field)
+	anyIndex := len(stripIndexes(want)) == len(want)
+	keys := make([]string, 0)
+	for k := range c.sources {
+		rest := k
+		if base != "" {
+			if k == base && field == "" {
+				keys = append(keys, k)
+				continue
+			}
+			if !strings.HasPrefix(k, base+".") {
+				continue
+			}
+			rest = k[len(base)+1:]
+		}
+		if field == "" {
+			continue
+		}
+		segs := splitPath(rest)
+		if anyIndex {
+			segs = stripIndexes(segs)
+		}
+		if equalFoldPath(segs, want) {
+			keys = append(keys, k)
+		}
+	}
+	if len(keys) == 0 {
+		return keyPos{}, false
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := c.sources[keys[i]], c.sources[keys[j]]
+		return a.line < b.line || a.line == b.line && a.col < b.col
+	})
+	return c.sourceOf(keys[0])
+}
+
+// stripIndexes returns segs without numeric segments.
+func stripIndexes(segs []string) []string {
+	res := make([]string, 0, len(segs))
+	for _, s := range segs {
+		if _, err := strconv.Atoi(s); err != nil {
+			res = append(res, s)
+		}
+	}
+	return res
+}
+
+func equalFoldPath(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !strings.EqualFold(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// forgetSource drops locations of key and its children after the value
+// was changed by Set.
+func (c *Config) forgetSource(key string) {
+	key = normPath(c.key(key))
+	for k := range c.sources {
+		if k == key || strings.HasPrefix(k, key+".") || strings.HasPrefix(k, key+"[") {
+			delete(c.sources, k)
+		}
+	}
+}
+
+// annotateSchema sets the source of schema errors using lookup.
+func annotateSchema(err error, lookup func(path string) (keyPos, bool)) error {
+	var errs SchemaErrors
+	if errors.As(err, &errs) {
+		for _, e := range errs {
+			if pos, ok := lookup(e.Path); ok && e.Path != "" {
+				e.Source = pos.String()
+			}
+		}
+	}
+	return err
+}
@@ -0,0 +1,359 @@
+// Copyright (c) 2018-2024 KIDTSUNAMI
+// Author: alex@kidtsunami.com
+
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Type is the expected value type of a registered key.
+type Type byte
+
+const (
+	TypeAny Type = iota
+	TypeString
+	TypeBool
+	TypeInt
+	TypeUint
+	TypeFloat
+	TypeDuration
+	TypeTime
+	TypeByteSize
+	TypeSlice
+	TypeMap
+)
+
+var typeNames = map[Type]string{
+	TypeAny:      "any",
+	TypeString:   "string",
+	TypeBool:     "bool",
+	TypeInt:      "int",
+	TypeUint:     "uint",
+	TypeFloat:    "float",
+	TypeDuration: "duration",
+	TypeTime:     "time",
+	TypeByteSize: "bytesize",
+	TypeSlice:    "slice",
+	TypeMap:      "map",
+}
+
+func (t Type) String() string {
+	if s, ok := typeNames[t]; ok {
+		return s
+	}
+	return "type(" + strconv.Itoa(int(t)) + ")"
+}
+
+// Spec declares a config key. Min and Max limit numeric, duration and
+// byte size values or the length of strings and slices. Pattern is a
+// regular expression that string values must match. Deprecated holds a
+// message shown when the key is still in use.
+type Spec struct {
+	Type        Type
+	Default     any
+	Description string
+	Required    bool
+	Min         any
+	Max         any
+	Pattern     string
+	Enum        []string
+	Deprecated  string
+	Secret      bool
+
+	re *regexp.Regexp
+}
+
+func Define(key string, spec Spec) error {
+	return config.Define(key, spec)
+}
+
+func Deprecations() []string {
+	return config.Deprecations()
+}
+
+// Define registers a key with its type, default, description and
+// constraints. Defaults and allowed values are registered as if set by
+// SetDefault and SetEnum. An invalid Pattern is returned as error and the
+// key is not registered.
+func (c *Config) Define(key string, spec Spec) error {
+	if c.parent != nil {
+		return c.parent.Define(c.fullPath(key), spec)
+	}
+	if spec.Pattern != "" {
+		re, err := regexp.Compile(spec.Pattern)
+		if err != nil {
+			return fmt.Errorf("config key %q: invalid pattern: %v", key, err)
+		}
+		spec.re = re
+	}
+	if c.specs == nil {
+		c.specs = make(map[string]*Spec)
+	}
+	c.specs[normPath(c.key(key))] = &spec
+	if len(spec.Enum) > 0 {
+		c.SetEnum(key, spec.Enum, "")
+	}
+	if spec.Default != nil {
+		c.SetDefault(key, spec.Default)
+	}
+	return nil
+}
+
+// Spec returns the registered spec for key.
+func (c *Config) Spec(key string) (Spec, bool) {
+	if c.parent != nil {
+		return c.parent.Spec(c.fullPath(key))
+	}
+	if s, ok := c.specs[normPath(c.key(key))]; ok {
+		return *s, true
+	}
+	return Spec{}, false
+}
+
+// Deprecations returns a message for every deprecated key that is set.
+// Views report keys below their path.
+func (c *Config) Deprecations() []string {
+	if c.parent != nil {
+		return c.parent.deprecations(c.subPath)
+	}
+	return c.deprecations("")
+}
+
+func (c *Config) deprecations(prefix string) []string {
+	msgs := make([]string, 0)
+	for _, k := range c.keysBelow(prefix) {
+		spec, ok := c.specs[k]
+		if !ok || spec.Deprecated == "" {
+			continue
+		}
+		// defaults do not count as use
+		_, isEnv := c.getEnv(k)
+		if isEnv || c.getIn(c.data, k) != nil {
+			msgs = append(msgs, fmt.Sprintf("config key %q is deprecated: %s", k, spec.Deprecated))
+		}
+	}
+	return msgs
+}
+
+// keysBelow returns the registered keys at or below prefix.
+func (c *Config) keysBelow(prefix string) []string {
+	keys := c.registeredKeys()
+	if prefix == "" {
+		return keys
+	}
+	res := keys[:0]
+	for _, k := range keys {
+		if underPath(k, prefix) {
+			res = append(res, k)
+		}
+	}
+	return res
+}
+
+// registeredKeys returns all keys known from defaults, enums and specs.
+func (c *Config) registeredKeys() []string {
+	keys := make([]string, 0, len(c.defaults)+len(c.specs))
+	for k := range c.defaults {
+		keys = append(keys, k)
+	}
+	for k := range c.enums {
+		if _, ok := c.defaults[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	for k := range c.specs {
+		_, ok1 := c.defaults[k]
+		_, ok2 := c.enums[k]
+		if !ok1 && !ok2 {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// validateSpec checks the current value of key against spec.
+func (c *Config) validateSpec(key string, spec *Spec) []error {
+	val := c.getValue(key)
+	if val == nil {
+		if spec.Required {
+			return []error{fmt.Errorf("missing required config key %q", key)}
+		}
+		return nil
+	}
+	v, err := spec.Type.convert(val)
+	if err != nil {
+		return []error{fmt.Errorf("config path %q: %v", key, err)}
+	}
+	errs := make([]error, 0)
+	if spec.Min != nil || spec.Max != nil {
+		n, ok := measure(v)
+		if ok && spec.Min != nil {
+			if lo, err := spec.limit(spec.Min); err != nil {
+				errs = append(errs, fmt.Errorf("config path %q: invalid min: %v", key, err))
+			} else if n < lo {
+				errs = append(errs, fmt.Errorf("config path %q: value %s is less than minimum %s", key, toString(v), toString(spec.Min)))
+			}
+		}
+		if ok && spec.Max != nil {
+			if hi, err := spec.limit(spec.Max); err != nil {
+				errs = append(errs, fmt.Errorf("config path %q: invalid max: %v", key, err))
+			} else if n > hi {
+				errs = append(errs, fmt.Errorf("config path %q: value %s exceeds maximum %s", key, toString(v), toString(spec.Max)))
+			}
+		}
+	}
+	if spec.re != nil {
+		if s := toString(val); !spec.re.MatchString(s) {
+			errs = append(errs, fmt.Errorf("config path %q: value %q does not match pattern %q", key, s, spec.Pattern))
+		}
+	}
+	return errs
+}
+
+// limit converts a Min or Max value into the measure of the spec type.
+// String and slice limits are lengths.
+func (s *Spec) limit(val any) (float64, error) {
+	t := s.Type
+	switch t {
+	case TypeString, TypeSlice, TypeMap, TypeAny:
+		t = TypeFloat
+	}
+	v, err := t.convert(val)
+	if err != nil {
+		return 0, err
+	}
+	n, _ := measure(v)
+	return n, nil
+}
+
+// convert checks that val can be interpreted as type t and returns the
+// converted value.
+func (t Type) convert(val any) (any, error) {
+	switch t {
+	case TypeString:
+		return toString(val), nil
+	case TypeBool:
+		if b, ok := val.(bool); ok {
+			return b, nil
+		}
+		b, err := strconv.ParseBool(toString(val))
+		if err != nil {
+			return nil, fmt.Errorf("invalid bool %q", toString(val))
+		}
+		return b, nil
+	case TypeInt:
+		return parseInt(val, 64)
+	case TypeUint:
+		return parseUint(val, 64)
+	case TypeFloat:
+		switch v := val.(type) {
+		case float64:
+			return v, nil
+		case float32:
+			return float64(v), nil
+		}
+		if i, err := parseInt(val, 64); err == nil {
+			return float64(i), nil
+		}
+		f, err := strconv.ParseFloat(strings.TrimSpace(toString(val)), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid float %q", toString(val))
+		}
+		return f, nil
+	case TypeDuration:
+		switch v := val.(type) {
+		case time.Duration:
+			return v, nil
+		case string:
+			d, err := ParseDuration(v)
+			return d.Duration(), err
+		}
+		i, err := parseInt(val, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid duration %q", toString(val))
+		}
+		return time.Duration(i), nil
+	case TypeTime:
+		if tm, ok := val.(time.Time); ok {
+			return tm, nil
+		}
+		return ParseTime(toString(val))
+	case TypeByteSize:
+		switch v := val.(type) {
+		case ByteSize:
+			return v, nil
+		case string:
+			return ParseByteSize(v)
+		}
+		u, err := parseUint(val, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid byte size %q", toString(val))
+		}
+		return ByteSize(u), nil
+	case TypeSlice:
+		return toStringSlice(val), nil
+	case TypeMap:
+		switch val.(type) {
+		case map[string]any, map[string]string:
+			return val, nil
+		}
+		return nil, fmt.Errorf("expected map, got %T", val)
+	}
+	return val, nil
+}
+
+// measure returns a comparable magnitude for min/max checks.
+func measure(v any) (float64, bool) {
+	switch n := v.(type) {
+	case int:
+		return float64(n), true
+	case int8:
+		return float64(n), true
+	case int16:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case uint:
+		return float64(n), true
+	case uint8:
+		return float64(n), true
+	case uint16:
+		return float64(n), true
+	case uint32:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	case float32:
+		return float64(n), true
+	case float64:
+		return n, true
+	case time.Duration:
+		return float64(n), true
+	case ByteSize:
+		return float64(n), true
+	case time.Time:
+		return float64(n.Unix()), true
+	case string:
+		return float64(len([]rune(n))), true
+	case []string:
+		return float64(len(n)), true
+	case map[string]any:
+		return float64(len(n)), true
+	case map[string]string:
+		return float64(len(n)), true
+	case json.Number:
+		f, err := n.Float64()
+		return f, err == nil
+	}
+	return 0, false
+}
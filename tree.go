@@ -5,96 +5,222 @@
 package config
 
 import (
+	"fmt"
+	"sort"
 	"strconv"
-	"strings"
 )
 
-func setTree(walker map[string]any, key string, val any) {
-	keys := strings.Split(key, ".")
-	for n := 0; n < len(keys); n++ {
-		v := keys[n]
-		if sub, ok := walker[v]; ok {
-			// recurse into subtree
-			switch e := sub.(type) {
-			case map[string]any:
-				walker = e
-			case []any:
-				i, _ := strconv.ParseInt(v, 10, 64)
-				walker = e[int(i)].(map[string]any)
-			default:
-				// append leaf if type is not a container
-				walker[v] = val
-			}
-		} else if n < len(keys)-1 {
-			// append subtree
-			sub := make(map[string]any)
-			walker[v] = sub
-			walker = sub
-		} else {
-			// append leaf
-			walker[v] = val
+// setTree sets val at the dotted key. Numeric segments address slice
+// elements, where an index equal to the slice length appends. Missing
+// containers are created as maps and scalars on the path are replaced.
+func setTree(walker map[string]any, key string, val any) error {
+	_, err := setNode(walker, splitPath(key), val, true, nil)
+	return err
+}
+
+// setTreeIfEmpty works like setTree but keeps existing values.
+func setTreeIfEmpty(walker map[string]any, key string, val any) error {
+	_, err := setNode(walker, splitPath(key), val, false, nil)
+	return err
+}
+
+// setNode sets val at keys below node. When n is set, existing map keys
+// match keys with the same normalized form and keep their spelling.
+func setNode(node any, keys []string, val any, overwrite bool, n KeyNormalizer) (any, error) {
+	if len(keys) == 0 {
+		if node != nil && !overwrite {
+			return node, nil
+		}
+		return val, nil
+	}
+	k := keys[0]
+	switch e := node.(type) {
+	case map[string]any:
+		k, _ = childKey(e, k, n)
+		if v, ok := e[k]; ok && v == nil && !overwrite {
+			// explicit null values hide defaults
+			return e, nil
+		}
+		sub, err := setNode(e[k], keys[1:], val, overwrite, n)
+		if err != nil {
+			return e, err
+		}
+		e[k] = sub
+		return e, nil
+	case []string:
+		s := make([]any, len(e))
+		for i, v := range e {
+			s[i] = v
+		}
+		return setNode(s, keys, val, overwrite, n)
+	case []any:
+		i, err := strconv.Atoi(k)
+		if err != nil || i < 0 {
+			return e, fmt.Errorf("invalid slice index %q", k)
+		}
+		if i > len(e) {
+			return e, fmt.Errorf("slice index %d out of range [0:%d]", i, len(e))
+		}
+		if i == len(e) {
+			e = append(e, nil)
 		}
+		sub, err := setNode(e[i], keys[1:], val, overwrite, n)
+		if err != nil {
+			return e, err
+		}
+		e[i] = sub
+		return e, nil
+	case nil:
+		sub, err := setNode(make(map[string]any), keys, val, overwrite, n)
+		return sub, err
+	default:
+		if !overwrite {
+			return node, nil
+		}
+		// replace scalar with subtree
+		return setNode(nil, keys, val, overwrite, n)
 	}
 }
 
-func setTreeIfEmpty(walker map[string]any, key string, val any) {
-	keys := strings.Split(key, ".")
-	for n := 0; n < len(keys); n++ {
-		v := keys[n]
-		if sub, ok := walker[v]; ok {
-			// recurse into subtree
-			switch e := sub.(type) {
-			case map[string]any:
-				walker = e
-			case []any:
-				i, _ := strconv.ParseInt(v, 10, 64)
-				walker = e[int(i)].(map[string]any)
-			default:
-				// append leaf if type is not a container and key segment is last
-				if n == len(keys)-1 {
-					if _, ok := walker[v]; !ok {
-						walker[v] = val
-					}
-				}
+// getTree returns the value at the dotted key or nil. Numeric segments
+// address slice elements.
+func getTree(walker map[string]any, key string) any {
+	return getNode(walker, splitPath(key))
+}
+
+func getNode(node any, keys []string) any {
+	val, _ := lookupNode(node, keys)
+	return val
+}
+
+// lookupNode returns the value at keys and whether it exists, which
+// allows to tell explicit null values from missing keys.
+func lookupNode(node any, keys []string) (any, bool) {
+	return lookupKeys(node, keys, nil)
+}
+
+// lookupKeys works like lookupNode. When n is set, map keys match keys
+// with the same normalized form.
+func lookupKeys(node any, keys []string, n KeyNormalizer) (any, bool) {
+	for _, k := range keys {
+		switch e := node.(type) {
+		case map[string]any:
+			k, ok := childKey(e, k, n)
+			if !ok {
+				return nil, false
+			}
+			node = e[k]
+		case []any:
+			i, err := strconv.Atoi(k)
+			if err != nil || i < 0 || i >= len(e) {
+				return nil, false
 			}
-		} else if n < len(keys)-1 {
-			// append subtree
-			sub := make(map[string]any)
-			walker[v] = sub
-			walker = sub
-		} else {
-			// append leaf
-			walker[v] = val
+			node = e[i]
+		case []string:
+			i, err := strconv.Atoi(k)
+			if err != nil || i < 0 || i >= len(e) {
+				return nil, false
+			}
+			node = e[i]
+		case nil:
+			// children of explicit null values are null
+			return nil, true
+		default:
+			return nil, false
 		}
 	}
+	return node, true
 }
 
-func getTree(walker map[string]any, key string) any {
-	keys := strings.Split(key, ".")
-	for n := 0; n < len(keys); n++ {
-		v := keys[n]
-		sub, ok := walker[v]
-		if !ok {
-			return nil
+// deleteTree removes the map entry at key. Slice elements are set to nil
+// to keep indexes stable.
+func deleteTree(walker map[string]any, key string) {
+	deleteKeys(walker, splitPath(key), nil)
+}
+
+func deleteKeys(walker map[string]any, keys []string, n KeyNormalizer) {
+	parent, ok := lookupKeys(walker, keys[:len(keys)-1], n)
+	if !ok {
+		return
+	}
+	k := keys[len(keys)-1]
+	switch e := parent.(type) {
+	case map[string]any:
+		k, _ = childKey(e, k, n)
+		delete(e, k)
+	case []any:
+		if i, err := strconv.Atoi(k); err == nil && i >= 0 && i < len(e) {
+			e[i] = nil
 		}
-		if n == len(keys)-1 {
-			return sub
+	}
+}
+
+// childKey returns the key of m that matches k. With normalizer keys
+// match when their normalized forms are equal, else k is returned.
+func childKey(m map[string]any, k string, n KeyNormalizer) (string, bool) {
+	if _, ok := m[k]; ok || n == nil {
+		return k, ok
+	}
+	nk := n.NormalizeKey(k)
+	match, found := k, false
+	for key := range m {
+		// pick the smallest match for stable results
+		if n.NormalizeKey(key) == nk && (!found || key < match) {
+			match, found = key, true
 		}
-		switch e := sub.(type) {
-		case map[string]any:
-			walker = e
-		case []any:
-			i, _ := strconv.ParseInt(v, 10, 64)
-			walker = e[int(i)].(map[string]any)
-		default:
-			break
+	}
+	return match, found
+}
+
+// copyTree returns a deep copy of maps and slices in node.
+func copyTree(node any) any {
+	switch e := node.(type) {
+	case map[string]any:
+		if e == nil {
+			return e
+		}
+		m := make(map[string]any, len(e))
+		for k, v := range e {
+			m[k] = copyTree(v)
+		}
+		return m
+	case []any:
+		s := make([]any, len(e))
+		for i, v := range e {
+			s[i] = copyTree(v)
+		}
+		return s
+	}
+	return node
+}
+
+// pruneNull removes map entries holding null from tree.
+func pruneNull(node any) {
+	switch e := node.(type) {
+	case map[string]any:
+		for k, v := range e {
+			if v == nil {
+				delete(e, k)
+			} else {
+				pruneNull(v)
+			}
+		}
+	case []any:
+		for _, v := range e {
+			pruneNull(v)
 		}
 	}
-	return nil
 }
 
 func walkTree(tree map[string]any, prefix string, fn func(key, val string) error) (err error) {
-	for n, v := range tree {
+	// visit keys in stable order
+	names := make([]string, 0, len(tree))
+	for n := range tree {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	for _, n := range names {
+		v := tree[n]
 		key := n
 		if prefix != "" {
 			key = prefix + "." + key
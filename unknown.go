@@ -0,0 +1,292 @@
+// Copyright (c) 2018-2024 KIDTSUNAMI
+// Author: alex@kidtsunami.com
+
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+func UnknownKeys() []*UnknownKey {
+	return config.UnknownKeys()
+}
+
+func SetStrict(strict bool) *Config {
+	return config.SetStrict(strict)
+}
+
+// UnknownKey is a key found in config data or env that does not match
+// any registered key. Suggestion holds the closest registered key, if any.
+type UnknownKey struct {
+	Key        string
+	Source     string // "data" or "env"
+	Suggestion string
+}
+
+func (k *UnknownKey) Error() string {
+	name := "config key"
+	if k.Source == "env" {
+		name = "env variable"
+	}
+	if k.Suggestion != "" {
+		return fmt.Sprintf("unknown %s %q (did you mean %q?)", name, k.Key, k.Suggestion)
+	}
+	return fmt.Sprintf("unknown %s %q", name, k.Key)
+}
+
+// SetStrict makes unknown keys a hard error when config data is read.
+// Unknown keys are only detected when keys are registered.
+func (c *Config) SetStrict(strict bool) *Config {
+	if c.parent != nil {
+		c.parent.SetStrict(strict)
+		return c
+	}
+	c.strict = strict
+	return c
+}
+
+// UnknownKeys lists keys in config data (from files and Set) and env
+// variables carrying the env prefix that match no registered key. Keys
+// are registered with SetDefault, SetEnum or Define. Returns nil when no
+// keys are registered. Views report keys below their path.
+func (c *Config) UnknownKeys() []*UnknownKey {
+	if c.parent != nil {
+		return c.parent.unknownBelow(c.subPath)
+	}
+	return c.unknownKeys(c.data)
+}
+
+// unknownBelow returns unknown keys at or below prefix and unknown env
+// variables named after prefix.
+func (c *Config) unknownBelow(prefix string) []*UnknownKey {
+	all := c.UnknownKeys()
+	if all == nil {
+		return nil
+	}
+	pfx := c.expandEnvKey(prefix)
+	res := make([]*UnknownKey, 0)
+	for _, k := range all {
+		if k.Source == "env" && strings.HasPrefix(k.Key, pfx+"_") || k.Source != "env" && underPath(k.Key, prefix) {
+			res = append(res, k)
+		}
+	}
+	return res
+}
+
+func (c *Config) unknownKeys(data map[string]any) []*UnknownKey {
+	reg := c.registeredKeys()
+	if len(reg) == 0 {
+		return nil
+	}
+	known := newKeySet(reg, c.isContainer)
+	res := make([]*UnknownKey, 0)
+
+	// walk data tree and stop at the first unknown level
+	var walk func(tree map[string]any, prefix string)
+	walk = func(tree map[string]any, prefix string) {
+		names := make([]string, 0, len(tree))
+		for n := range tree {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		for _, n := range names {
+			nk := n
+			if c.keyNorm != nil {
+				nk = c.keyNorm.NormalizeKey(n)
+			}
+			key := joinPath(prefix, nk)
+			switch known.match(key) {
+			case keyUnknown:
+				res = append(res, &UnknownKey{
+					Key:        key,
+					Source:     "data",
+					Suggestion: closestKey(key, known.candidates()),
+				})
+			case keyParent:
+				if sub, ok := tree[n].(map[string]any); ok {
+					walk(sub, key)
+				}
+			case keyKnown:
+				// children of scalar keys are unknown
+				if sub, ok := tree[n].(map[string]any); ok && known.keys[key] && !known.open[key] {
+					walk(sub, key)
+				}
+			}
+		}
+	}
+	walk(data, "")
+
+	if c.noEnv || c.envPrefix == "" {
+		return res
+	}
+
+	// env variables are matched by name
+	envKnown := make(map[string]string, len(reg))
+	envNames := make([]string, 0, len(reg))
+	envOpen := make([]string, 0)
+	for _, k := range reg {
+		name := c.expandEnvKey(k)
+		envKnown[name] = k
+		envNames = append(envNames, name)
+		if known.open[k] {
+			envOpen = append(envOpen, name)
+		}
+	}
+	pfx := c.envPrefix + "_"
+	env := os.Environ()
+	sort.Strings(env)
+	for _, v := range env {
+		if !strings.HasPrefix(v, pfx) {
+			continue
+		}
+		name, _, _ := strings.Cut(v, "=")
+		if c.isControlEnv(name) {
+			continue
+		}
+		if _, ok := envKnown[name]; ok {
+			continue
+		}
+		found := false
+		for _, n := range envOpen {
+			// nested keys below registered maps and slices
+			if strings.HasPrefix(name, n+"_") {
+				found = true
+				break
+			}
+		}
+		if found {
+			continue
+		}
+		res = append(res, &UnknownKey{
+			Key:        name,
+			Source:     "env",
+			Suggestion: closestKey(name, envNames),
+		})
+	}
+	return res
+}
+
+// checkUnknown returns all unknown keys in data as joined error.
+func (c *Config) checkUnknown(data map[string]any) error {
+	unknown := c.unknownKeys(data)
+	if len(unknown) == 0 {
+		return nil
+	}
+	errs := make([]error, len(unknown))
+	for i, v := range unknown {
+		errs[i] = v
+	}
+	return errors.Join(errs...)
+}
+
+const (
+	keyUnknown = iota
+	keyParent  // key is an ancestor of a registered key
+	keyKnown   // key is registered or below a registered map or slice
+)
+
+type keySet struct {
+	keys    map[string]bool
+	parents map[string]bool
+	open    map[string]bool // registered maps and slices
+}
+
+// newKeySet builds the set of registered keys. Keys below a registered
+// key are only known when isOpen reports it as map or slice.
+func newKeySet(keys []string, isOpen func(key string) bool) *keySet {
+	s := &keySet{
+		keys:    make(map[string]bool),
+		parents: make(map[string]bool),
+		open:    make(map[string]bool),
+	}
+	for _, k := range keys {
+		s.keys[k] = true
+		if isOpen(k) {
+			s.open[k] = true
+		}
+		for i := strings.LastIndexByte(k, '.'); i > 0; i = strings.LastIndexByte(k[:i], '.') {
+			s.parents[k[:i]] = true
+		}
+	}
+	return s
+}
+
+func (s *keySet) match(key string) int {
+	if s.keys[key] {
+		return keyKnown
+	}
+	for i := strings.LastIndexByte(key, '.'); i > 0; i = strings.LastIndexByte(key[:i], '.') {
+		if s.open[key[:i]] {
+			return keyKnown
+		}
+	}
+	if s.parents[key] {
+		return keyParent
+	}
+	return keyUnknown
+}
+
+func (s *keySet) candidates() []string {
+	res := make([]string, 0, len(s.keys)+len(s.parents))
+	for k := range s.keys {
+		res = append(res, k)
+	}
+	for k := range s.parents {
+		res = append(res, k)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// closestKey returns the candidate with the smallest edit distance to key
+// or an empty string when no candidate is reasonably close.
+func closestKey(key string, candidates []string) string {
+	best, bestDist := "", -1
+	for _, c := range candidates {
+		d := editDistance(strings.ToLower(key), strings.ToLower(c))
+		if bestDist < 0 || d < bestDist {
+			best, bestDist = c, d
+		}
+	}
+	// suggestions beyond a third of the key length are mostly noise
+	if bestDist < 0 || bestDist > (len(key)+2)/3 {
+		return ""
+	}
+	return best
+}
+
+// editDistance computes the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = minInt(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(rb)]
+}
+
+func minInt(v ...int) int {
+	m := v[0]
+	for _, x := range v[1:] {
+		if x < m {
+			m = x
+		}
+	}
+	return m
+}
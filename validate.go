@@ -0,0 +1,74 @@
+// Copyright (c) 2018-2024 KIDTSUNAMI
+// Author: alex@kidtsunami.com
+
+package config
+
+import (
+	"errors"
+)
+
+func Validate() error {
+	return config.Validate()
+}
+
+// Validate checks the merged config against all registered constraints
+// and reports every violation at once. Views check keys below their path.
+func (c *Config) Validate() error {
+	if c.parent != nil {
+		return c.parent.validate(c.subPath)
+	}
+	return c.validate("")
+}
+
+func (c *Config) validate(prefix string) error {
+	errs := make([]error, 0)
+	for _, k := range c.keysBelow(prefix) {
+		if spec, ok := c.specs[k]; ok {
+			if errs2 := c.validateSpec(k, spec); len(errs2) > 0 {
+				for _, err := range errs2 {
+					errs = append(errs, c.withSource(k, err))
+				}
+				continue
+			}
+		}
+		if _, ok := c.enums[k]; ok {
+			if _, err := c.GetEnum(k); err != nil {
+				errs = append(errs, c.withSource(k, err))
+			}
+		}
+	}
+	// unresolved references, cycles and missing secrets
+	if prefix == "" {
+		if _, err := c.allE(); err != nil {
+			errs = append(errs, err)
+		}
+	} else if _, err := c.getValueE(prefix); err != nil {
+		errs = append(errs, err)
+	}
+	if c.schema != nil {
+		if err := c.schema.validate(c.All()); err != nil {
+			if err = schemaBelow(err, prefix); err != nil {
+				errs = append(errs, annotateSchema(err, c.sourceOf))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// schemaBelow drops schema errors outside of prefix.
+func schemaBelow(err error, prefix string) error {
+	var errs SchemaErrors
+	if prefix == "" || !errors.As(err, &errs) {
+		return err
+	}
+	res := make(SchemaErrors, 0, len(errs))
+	for _, e := range errs {
+		if underPath(normPath(e.Path), prefix) {
+			res = append(res, e)
+		}
+	}
+	if len(res) == 0 {
+		return nil
+	}
+	return res
+}
@@ -0,0 +1,44 @@
+// Copyright (c) 2018-2024 KIDTSUNAMI
+// Author: alex@kidtsunami.com
+
+package config
+
+import (
+	"strings"
+)
+
+func Sub(path string) *Config {
+	return config.Sub(path)
+}
+
+// Sub returns a view of the sub-tree at path. Unlike Branch, which
+// copies the sub-tree, a view holds no data of its own. Reads resolve
+// through all layers of the parent, including env and defaults
+// registered below path, and see later changes and reloads. Set,
+// SetDefault, Use, Encrypt and key registrations write to the parent at
+// the prefixed path, settings like SetInterpolate or SetStrict change the
+// parent. ReadConfig and ReadConfigFile fail on views. The sub-tree does
+// not need to exist.
+func (c *Config) Sub(path string) *Config {
+	if c.parent != nil {
+		return c.parent.Sub(c.fullPath(path))
+	}
+	v := NewConfig()
+	v.parent = c
+	v.subPath = c.key(normPath(path))
+	return v
+}
+
+// fullPath returns path prefixed with the path of a view.
+func (c *Config) fullPath(path string) string {
+	if path == "" {
+		return c.subPath
+	}
+	return formatPath(append(splitPath(c.subPath), splitPath(path)...), nil)
+}
+
+// underPath reports whether key is prefix or below prefix. Every key is
+// below the empty prefix.
+func underPath(key, prefix string) bool {
+	return prefix == "" || key == prefix || strings.HasPrefix(key, prefix+".") || strings.HasPrefix(key, prefix+"[")
+}
@@ -0,0 +1,248 @@
+// Copyright (c) 2018-2024 KIDTSUNAMI
+// Author: alex@kidtsunami.com
+
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// ValueKind is the JSON kind of a config value.
+type ValueKind byte
+
+const (
+	KindInvalid ValueKind = iota // path does not exist
+	KindNull
+	KindBool
+	KindNumber
+	KindString
+	KindSlice
+	KindMap
+)
+
+var kindNames = map[ValueKind]string{
+	KindInvalid: "invalid",
+	KindNull:    "null",
+	KindBool:    "bool",
+	KindNumber:  "number",
+	KindString:  "string",
+	KindSlice:   "slice",
+	KindMap:     "map",
+}
+
+func (k ValueKind) String() string {
+	if s, ok := kindNames[k]; ok {
+		return s
+	}
+	return "kind(" + strconv.Itoa(int(k)) + ")"
+}
+
+func kindOf(val any) ValueKind {
+	switch val.(type) {
+	case nil:
+		return KindNull
+	case bool:
+		return KindBool
+	case json.Number, int, int8, int16, int32, int64, uint, uint8, uint16,
+		uint32, uint64, float32, float64, time.Duration, ByteSize:
+		return KindNumber
+	case []any, []string:
+		return KindSlice
+	case map[string]any, map[string]string:
+		return KindMap
+	default:
+		return KindString
+	}
+}
+
+// Value is a typed config value as seen by Walk.
+type Value struct {
+	val any
+}
+
+// Interface returns the underlying value.
+func (v Value) Interface() any {
+	return v.val
+}
+
+func (v Value) Kind() ValueKind {
+	return kindOf(v.val)
+}
+
+func (v Value) String() string {
+	return toString(v.val)
+}
+
+// Len returns the number of elements of a slice or map value.
+func (v Value) Len() int {
+	return lenOf(v.val)
+}
+
+func lenOf(val any) int {
+	switch n := val.(type) {
+	case []any:
+		return len(n)
+	case []string:
+		return len(n)
+	case map[string]any:
+		return len(n)
+	case map[string]string:
+		return len(n)
+	}
+	return 0
+}
+
+// SkipBranch may be returned by a Walk callback to skip the children of
+// the current map or slice.
+var SkipBranch = errors.New("skip branch")
+
+func Walk(fn func(path string, v Value) error) error {
+	return config.Walk(fn)
+}
+
+func Keys(prefix string) []string {
+	return config.Keys(prefix)
+}
+
+func Children(path string) []string {
+	return config.Children(path)
+}
+
+func Len(path string) int {
+	return config.Len(path)
+}
+
+func Kind(path string) ValueKind {
+	return config.Kind(path)
+}
+
+// Walk calls fn for every value in the merged tree, maps and slices
+// before their elements. Map keys are visited in sorted order, slice
+// elements in index order. Paths use bracket form for slice indexes.
+func (c *Config) Walk(fn func(path string, v Value) error) error {
+	all, err := c.allE()
+	if err != nil {
+		return err
+	}
+	err = walkNode(all, nil, func(segs []string, val any) error {
+		return fn(formatPath(segs, all), Value{val})
+	})
+	if err == SkipBranch {
+		err = nil
+	}
+	return err
+}
+
+func walkNode(node any, segs []string, fn func(segs []string, val any) error) error {
+	names := childNames(node)
+	for _, n := range names {
+		sub := getNode(node, []string{n})
+		path := append(segs[:len(segs):len(segs)], n)
+		if err := fn(path, sub); err != nil {
+			if err == SkipBranch {
+				continue
+			}
+			return err
+		}
+		if err := walkNode(sub, path, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// childNames returns sorted map keys or slice indexes of node.
+func childNames(node any) []string {
+	var names []string
+	switch n := node.(type) {
+	case map[string]any:
+		names = make([]string, 0, len(n))
+		for k := range n {
+			names = append(names, k)
+		}
+		sort.Strings(names)
+	case map[string]string:
+		names = make([]string, 0, len(n))
+		for k := range n {
+			names = append(names, k)
+		}
+		sort.Strings(names)
+	case []any, []string:
+		l := lenOf(n)
+		names = make([]string, l)
+		for i := range names {
+			names[i] = strconv.Itoa(i)
+		}
+	}
+	return names
+}
+
+// Keys returns the paths of all leaf values at or below prefix in
+// sorted order. An empty prefix lists all keys.
+func (c *Config) Keys(prefix string) []string {
+	keys := make([]string, 0)
+	all := c.All()
+	var (
+		node any = all
+		segs []string
+	)
+	if prefix != "" {
+		segs = splitPath(c.key(prefix))
+		node, _ = c.lookupIn(all, segs)
+		if node == nil {
+			return keys
+		}
+	}
+	if k := kindOf(node); k != KindMap && k != KindSlice {
+		return append(keys, formatPath(segs, all))
+	}
+	_ = walkNode(node, segs, func(path []string, val any) error {
+		if k := kindOf(val); k != KindMap && k != KindSlice {
+			keys = append(keys, formatPath(path, all))
+		}
+		return nil
+	})
+	return keys
+}
+
+// Children returns the keys of the map at path in sorted order or the
+// indexes of the slice at path. An empty path lists top-level keys.
+func (c *Config) Children(path string) []string {
+	names := childNames(c.node(path))
+	if names == nil {
+		names = make([]string, 0)
+	}
+	return names
+}
+
+// Len returns the number of elements of the slice or map at path and
+// zero for other values.
+func (c *Config) Len(path string) int {
+	return lenOf(c.node(path))
+}
+
+// Kind returns the kind of the value at path or KindInvalid when path
+// does not exist.
+func (c *Config) Kind(path string) ValueKind {
+	if path == "" {
+		return KindMap
+	}
+	v, state := c.Lookup(path)
+	if state == Missing {
+		return KindInvalid
+	}
+	return v.Kind()
+}
+
+// node returns the resolved value at path or the full tree for an empty
+// path.
+func (c *Config) node(path string) any {
+	if path == "" {
+		return c.All()
+	}
+	return c.getValue(path)
+}
@@ -0,0 +1,149 @@
+// Copyright (c) 2018-2024 KIDTSUNAMI
+// Author: alex@kidtsunami.com
+
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+func WriteConfigFile(name string) error {
+	return config.WriteConfigFile(name)
+}
+
+func SafeWriteConfigFile(name string) error {
+	return config.SafeWriteConfigFile(name)
+}
+
+func SetWriteBackup(enabled bool) *Config {
+	return config.SetWriteBackup(enabled)
+}
+
+// SetWriteBackup keeps a copy of the previous config file with suffix
+// .bak when a config file is overwritten.
+func (c *Config) SetWriteBackup(enabled bool) *Config {
+	if c.parent != nil {
+		c.parent.SetWriteBackup(enabled)
+		return c
+	}
+	c.backup = enabled
+	return c
+}
+
+// WriteConfigFile writes config data read from files or stored with Set
+// to the named file or to ConfigName when name is empty. Defaults and env
+// values are not written. The file is replaced atomically by writing a
+// temporary file first, so readers never see partial content. Existing
+// file permissions are kept. Existing JSONC and JSON5 files are not
+// overwritten since their comments would be lost, use Patch instead.
+func (c *Config) WriteConfigFile(name string) error {
+	return c.writeConfigFile(name, false)
+}
+
+// SafeWriteConfigFile works like WriteConfigFile but fails when the file
+// already exists.
+func (c *Config) SafeWriteConfigFile(name string) error {
+	return c.writeConfigFile(name, true)
+}
+
+func (c *Config) writeConfigFile(name string, safe bool) error {
+	if c.parent != nil {
+		return c.parent.writeConfigFile(name, safe)
+	}
+	if name == "" {
+		name = c.ConfigName()
+	}
+	buf, err := json.MarshalIndent(c.data, "", "  ")
+	if err != nil {
+		return fmt.Errorf("writing config file: %v", err)
+	}
+	buf = append(buf, '\n')
+
+	var perm fs.FileMode = 0o644
+	if safe {
+		// fails when name exists, also when it is created concurrently
+		if err := writeFile(name, buf, perm, true); err != nil {
+			return fmt.Errorf("writing config file: %w", err)
+		}
+		return nil
+	}
+	fi, err := os.Stat(name)
+	switch {
+	case err == nil:
+		if f := c.configFormat(name); f != FormatJSON {
+			return fmt.Errorf("writing config file: cannot rewrite %s file %s, use Patch", f, name)
+		}
+		perm = fi.Mode().Perm()
+		if c.backup {
+			if err := copyFile(name, name+".bak", perm); err != nil {
+				return fmt.Errorf("writing config file backup: %v", err)
+			}
+		}
+	case !os.IsNotExist(err):
+		return fmt.Errorf("writing config file: %v", err)
+	}
+	if err := writeFileAtomic(name, buf, perm); err != nil {
+		return fmt.Errorf("writing config file: %v", err)
+	}
+	return nil
+}
+
+// writeFileAtomic writes buf to a temporary file in the directory of name,
+// syncs it and renames it to name.
+func writeFileAtomic(name string, buf []byte, perm fs.FileMode) error {
+	return writeFile(name, buf, perm, false)
+}
+
+// writeFile works like writeFileAtomic. With excl the temporary file is
+// linked to name instead, which fails when name exists.
+func writeFile(name string, buf []byte, perm fs.FileMode, excl bool) (err error) {
+	dir := filepath.Dir(name)
+	f, err := os.CreateTemp(dir, "."+filepath.Base(name)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}()
+	if _, err = f.Write(buf); err != nil {
+		return err
+	}
+	if err = f.Chmod(perm); err != nil {
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	if excl {
+		if err = os.Link(f.Name(), name); err != nil {
+			return err
+		}
+		os.Remove(f.Name())
+	} else if err = os.Rename(f.Name(), name); err != nil {
+		return err
+	}
+	// persist the directory entry, not supported on all platforms
+	if d, err := os.Open(dir); err == nil {
+		_ = d.Sync()
+		d.Close()
+	}
+	return nil
+}
+
+func copyFile(src, dst string, perm fs.FileMode) error {
+	buf, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(dst, buf, perm)
+}